@@ -0,0 +1,20 @@
+// Command gorexvet reports misuses of gorex mutexes (see package
+// github.com/xaionaro-go/gorex/analysis/gorexcheck), including copying
+// of them by value (see package
+// golang.org/x/tools/go/analysis/passes/copylock, which is linked in:
+// "go vet -vettool" runs only the analyzers of the tool).
+//
+// Usage:
+//
+//	go vet -vettool=$(which gorexvet) ./...
+package main
+
+import (
+	"github.com/xaionaro-go/gorex/analysis/gorexcheck"
+	"golang.org/x/tools/go/analysis/passes/copylock"
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+func main() {
+	unitchecker.Main(gorexcheck.Analyzer, copylock.Analyzer)
+}
@@ -0,0 +1,174 @@
+// Package gorexcheck defines an Analyzer that reports misuses of
+// github.com/xaionaro-go/gorex mutexes.
+//
+// It could be used with "go vet" (see github.com/xaionaro-go/gorex/analysis/cmd/gorexvet):
+//
+//	go install github.com/xaionaro-go/gorex/analysis/cmd/gorexvet@latest
+//	go vet -vettool=$(which gorexvet) ./...
+package gorexcheck
+
+import (
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/ctrlflow"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const doc = `check for misuses of gorex mutexes
+
+The gorexcheck analysis reports:
+  - Lock/RLock/UpgradeableRLock (or a successful LockTry, LockCtx, etc.)
+    which is not followed by the matching unlock on all paths of
+    the function (functions named as the locking methods, for example
+    "func (t *T) Lock()", are considered wrappers and are skipped);
+  - a lock released by an unlock of the wrong kind (for example,
+    RUnlock after Lock);
+  - Lock/LockDo/LockCtx/LockCtxDo inside RLockDo on the same RWMutex,
+    which deadlocks if two goroutines do it concurrently (use
+    UpgradeableRLock instead);
+  - goroutines started inside LockDo/RLockDo which use the same mutex:
+    the lock is owned by the parent goroutine, not by the new one.
+
+Copying of gorex mutexes is not reported by this analyzer, use it together
+with the "copylocks" analyzer (golang.org/x/tools/go/analysis/passes/copylock)
+for that, as the gorexvet command does.`
+
+const gorexPkgPath = "github.com/xaionaro-go/gorex"
+
+// Analyzer reports misuses of gorex mutexes.
+var Analyzer = &analysis.Analyzer{
+	Name:             "gorexcheck",
+	Doc:              doc,
+	URL:              "https://pkg.go.dev/github.com/xaionaro-go/gorex/analysis/gorexcheck",
+	Requires:         []*analysis.Analyzer{inspect.Analyzer, ctrlflow.Analyzer},
+	Run:              run,
+	RunDespiteErrors: true,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	cfgs := pass.ResultOf[ctrlflow.Analyzer].(*ctrlflow.CFGs)
+
+	nodeFilter := []ast.Node{
+		(*ast.FuncDecl)(nil),
+		(*ast.FuncLit)(nil),
+		(*ast.CallExpr)(nil),
+	}
+	inspect.Preorder(nodeFilter, func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.FuncDecl:
+			// a function named as an acquiring method (for example
+			// "func (t *T) Lock()") is a wrapper, which should leave
+			// the lock held
+			if node.Body != nil && !isAcquireMethod(node.Name.Name) {
+				checkUnlocks(pass, node.Body, cfgs.FuncDecl(node))
+			}
+		case *ast.FuncLit:
+			checkUnlocks(pass, node.Body, cfgs.FuncLit(node))
+		case *ast.CallExpr:
+			checkLockDo(pass, node)
+		}
+	})
+	return nil, nil
+}
+
+// gorexCall is a call of a method of gorex.Mutex or gorex.RWMutex.
+type gorexCall struct {
+	Call *ast.CallExpr
+
+	// Recv is the expression the method is called on.
+	Recv ast.Expr
+
+	// Method is the name of the called method (for example "Lock").
+	Method string
+}
+
+// RecvString returns the textual representation of the receiver,
+// which is used to match calls on the same mutex.
+func (c *gorexCall) RecvString() string {
+	return types.ExprString(c.Recv)
+}
+
+func (c *gorexCall) String() string {
+	return c.RecvString() + "." + c.Method
+}
+
+// asGorexCall returns the description of the call if it is a call of a method
+// of gorex.Mutex or gorex.RWMutex (including promoted methods of types
+// embedding them). Returns nil otherwise.
+func asGorexCall(info *types.Info, expr ast.Expr) *gorexCall {
+	call, ok := astutil.Unparen(expr).(*ast.CallExpr)
+	if !ok {
+		return nil
+	}
+	sel, ok := astutil.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	selection := info.Selections[sel]
+	if selection == nil || selection.Kind() != types.MethodVal {
+		return nil
+	}
+	fn, ok := selection.Obj().(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil || !isGorexMutex(recv.Type()) {
+		return nil
+	}
+	return &gorexCall{
+		Call:   call,
+		Recv:   astutil.Unparen(sel.X),
+		Method: fn.Name(),
+	}
+}
+
+// isGorexMutex returns true if the type is gorex.Mutex or gorex.RWMutex
+// (or a pointer to one of them).
+func isGorexMutex(t types.Type) bool {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	if obj.Pkg() == nil || obj.Pkg().Path() != gorexPkgPath {
+		return false
+	}
+	switch obj.Name() {
+	case "Mutex", "RWMutex":
+		return true
+	}
+	return false
+}
+
+// inspectCalls calls "fn" for each gorex call within "node" in the order of
+// the source code. Function literals, "go" and "defer" statements are skipped
+// (they are executed out of order or by another goroutine).
+func inspectCalls(info *types.Info, node ast.Node, fn func(*gorexCall)) {
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit, *ast.GoStmt, *ast.DeferStmt:
+			return false
+		case *ast.CallExpr:
+			// visit arguments first: they are evaluated before the call
+			for _, arg := range node.Args {
+				inspectCalls(info, arg, fn)
+			}
+			if call := asGorexCall(info, node); call != nil {
+				fn(call)
+				return false
+			}
+			inspectCalls(info, node.Fun, fn)
+			return false
+		}
+		return true
+	})
+}
@@ -0,0 +1,12 @@
+package gorexcheck_test
+
+import (
+	"testing"
+
+	"github.com/xaionaro-go/gorex/analysis/gorexcheck"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), gorexcheck.Analyzer, "a")
+}
@@ -0,0 +1,93 @@
+package gorexcheck
+
+import (
+	"go/ast"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// lockDoMethods is the set of methods which call the function (passed as
+// the last argument) while the lock is held.
+var lockDoMethods = map[string]bool{
+	"LockDo":             true,
+	"LockTryDo":          true,
+	"LockCtxDo":          true,
+	"RLockDo":            true,
+	"RLockTryDo":         true,
+	"RLockCtxDo":         true,
+	"UpgradeableRLockDo": true,
+}
+
+// writeLockMethods is the set of methods which wait for a write lock.
+var writeLockMethods = map[string]bool{
+	"Lock":      true,
+	"LockDo":    true,
+	"LockCtx":   true,
+	"LockCtxDo": true,
+}
+
+// checkLockDo checks the function literal passed to LockDo-like methods:
+//   - it should not acquire a write lock of the same mutex if called
+//     by RLockDo (see "But..." in README.md);
+//   - goroutines started within it should not use the same mutex.
+func checkLockDo(pass *analysis.Pass, node *ast.CallExpr) {
+	call := asGorexCall(pass.TypesInfo, node)
+	if call == nil || !lockDoMethods[call.Method] || len(node.Args) == 0 {
+		return
+	}
+	fn, ok := astutil.Unparen(node.Args[len(node.Args)-1]).(*ast.FuncLit)
+	if !ok {
+		return
+	}
+	recv := call.RecvString()
+	isRead := strings.HasPrefix(call.Method, "RLock")
+
+	ast.Inspect(fn.Body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.GoStmt:
+			checkGoInLockDo(pass, call, node)
+			return false
+		case *ast.CallExpr:
+			if !isRead {
+				return true
+			}
+			inner := asGorexCall(pass.TypesInfo, node)
+			if inner == nil || inner.RecvString() != recv || !writeLockMethods[inner.Method] {
+				return true
+			}
+			pass.Reportf(node.Pos(), "%s() inside %s() deadlocks if another goroutine does the same, use %s.UpgradeableRLockDo() and %s.Upgrade() instead",
+				inner, call, recv, recv)
+		}
+		return true
+	})
+}
+
+// checkGoInLockDo reports the "go" statement if the started goroutine
+// uses the mutex locked by lockDo.
+func checkGoInLockDo(pass *analysis.Pass, lockDo *gorexCall, stmt *ast.GoStmt) {
+	recv := lockDo.RecvString()
+	var usage *gorexCall
+	ast.Inspect(stmt.Call, func(node ast.Node) bool {
+		if usage != nil {
+			return false
+		}
+		expr, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if call := asGorexCall(pass.TypesInfo, expr); call != nil && call.RecvString() == recv {
+			usage = call
+			return false
+		}
+		return true
+	})
+	if usage == nil {
+		return
+	}
+	pass.Reportf(stmt.Pos(), "goroutine started inside %s() calls %s(), but the lock is owned by the parent goroutine",
+		lockDo, usage)
+}
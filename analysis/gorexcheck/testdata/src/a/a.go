@@ -0,0 +1,271 @@
+package a
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/xaionaro-go/gorex"
+)
+
+type entity struct {
+	gorex.Mutex
+	value int
+}
+
+type rwEntity struct {
+	locker gorex.RWMutex
+	value  int
+}
+
+var errSomething = errors.New("something")
+
+func unlockDeferred(ent *entity) {
+	ent.Lock()
+	defer ent.Unlock()
+	ent.value++
+}
+
+func unlockDeferredFuncLit(ent *entity) {
+	ent.Lock()
+	defer func() {
+		ent.Unlock()
+	}()
+	ent.value++
+}
+
+func unlockOnAllPaths(ent *entity) error {
+	ent.Lock()
+	if ent.value == 0 {
+		ent.Unlock()
+		return errSomething
+	}
+	ent.value++
+	ent.Unlock()
+	return nil
+}
+
+func unlockMissingOnEarlyReturn(ent *entity) error {
+	ent.Lock() // want `ent.Lock\(\) is not followed by ent.Unlock\(\) on all paths`
+	if ent.value == 0 {
+		return errSomething
+	}
+	ent.value++
+	ent.Unlock()
+	return nil
+}
+
+func unlockMissing(ent *entity) {
+	ent.Lock() // want `ent.Lock\(\) is not followed by ent.Unlock\(\) on all paths`
+	ent.value++
+}
+
+func unlockMissingPanic(ent *entity) {
+	ent.Lock()
+	if ent.value == 0 {
+		panic("value is zero")
+	}
+	ent.Unlock()
+}
+
+func reentrant(ent *entity) {
+	ent.Lock()
+	ent.Lock()
+	ent.value++
+	ent.Unlock()
+	ent.Unlock()
+}
+
+func reentrantMissing(ent *entity) {
+	ent.Lock() // want `ent.Lock\(\) is not followed by ent.Unlock\(\) on all paths`
+	ent.Lock()
+	ent.value++
+	ent.Unlock()
+}
+
+func loop(ent *entity) {
+	for i := 0; i < 10; i++ {
+		ent.Lock()
+		ent.value++
+		ent.Unlock()
+	}
+}
+
+func rUnlockAfterLock(ent *rwEntity) {
+	ent.locker.Lock()
+	ent.value++
+	ent.locker.RUnlock() // want `ent.locker.RUnlock\(\) releases the lock acquired by ent.locker.Lock\(\), should be ent.locker.Unlock\(\)`
+}
+
+func unlockAfterRLock(ent *rwEntity) int {
+	ent.locker.RLock()
+	defer ent.locker.Unlock() // want `ent.locker.Unlock\(\) releases the lock acquired by ent.locker.RLock\(\), should be ent.locker.RUnlock\(\)`
+	return ent.value
+}
+
+func downgrade(ent *rwEntity) int {
+	ent.locker.Lock()
+	ent.value++
+	ent.locker.Downgrade()
+	defer ent.locker.RUnlock()
+	return ent.value
+}
+
+func upgrade(ent *rwEntity) {
+	ent.locker.UpgradeableRLock()
+	defer ent.locker.UpgradeableRUnlock()
+	if ent.value != 0 {
+		return
+	}
+	ent.locker.Upgrade()
+	ent.value++
+	ent.locker.Downgrade()
+}
+
+func upgradeMissingDowngrade(ent *rwEntity) {
+	ent.locker.UpgradeableRLock()
+	ent.locker.Upgrade()
+	ent.value++
+	ent.locker.UpgradeableRUnlock() // want `ent.locker.UpgradeableRUnlock\(\) releases the lock acquired by ent.locker.Upgrade\(\), should be ent.locker.Unlock\(\)`
+}
+
+func lockTry(ent *entity) {
+	if !ent.LockTry() {
+		return
+	}
+	defer ent.Unlock()
+	ent.value++
+}
+
+func lockTryMissing(ent *entity) {
+	if ent.LockTry() { // want `ent.LockTry\(\) is not followed by ent.Unlock\(\) on all paths`
+		if ent.value == 0 {
+			return
+		}
+		ent.Unlock()
+	}
+}
+
+func lockCtx(ctx context.Context, ent *entity) bool {
+	if !ent.LockCtx(ctx) {
+		return false
+	}
+	defer ent.UnlockCtx(ctx)
+	ent.value++
+	return true
+}
+
+func lockTimeoutResult(ent *rwEntity) error {
+	ok := ent.locker.LockTimeout(time.Second)
+	if !ok {
+		return errSomething
+	}
+	ent.value++
+	ent.locker.Unlock()
+	return nil
+}
+
+func lockTryInsideRLock(ent *rwEntity) {
+	ent.locker.RLock()
+	defer ent.locker.RUnlock()
+	if ent.locker.LockTry() {
+		ent.value++
+		ent.locker.Unlock()
+	}
+}
+
+func rLockCtxWrongUnlock(ctx context.Context, ent *rwEntity) int {
+	if !ent.locker.RLockCtx(ctx) {
+		return 0
+	}
+	defer ent.locker.Unlock() // want `ent.locker.Unlock\(\) releases the lock acquired by ent.locker.RLock\(\), should be ent.locker.RUnlock\(\)`
+	return ent.value
+}
+
+func upgradeCtx(ctx context.Context, ent *rwEntity) {
+	ent.locker.UpgradeableRLock()
+	defer ent.locker.UpgradeableRUnlock()
+	for !ent.locker.UpgradeCtx(ctx) {
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	ent.value++
+	ent.locker.Downgrade()
+}
+
+func lockTryUnknownResult(ent *entity) bool {
+	return ent.LockTry()
+}
+
+type wrapper struct {
+	locker gorex.RWMutex
+}
+
+func (w *wrapper) Lock() {
+	w.locker.Lock()
+}
+
+func (w *wrapper) Unlock() {
+	w.locker.Unlock()
+}
+
+func (w *wrapper) RLockTry() bool {
+	return w.locker.RLockTry()
+}
+
+func (w *wrapper) UpgradeableRLockCtx(ctx context.Context) bool {
+	if !w.locker.UpgradeableRLockCtx(ctx) {
+		return false
+	}
+	return true
+}
+
+func (w *wrapper) lock() {
+	w.locker.Lock() // want `w.locker.Lock\(\) is not followed by w.locker.Unlock\(\) on all paths`
+}
+
+func lockInsideRLockDo(ent *rwEntity) {
+	ent.locker.RLockDo(func() {
+		if ent.value != 0 {
+			return
+		}
+		ent.locker.LockDo(func() { // want `ent.locker.LockDo\(\) inside ent.locker.RLockDo\(\) deadlocks if another goroutine does the same, use ent.locker.UpgradeableRLockDo\(\) and ent.locker.Upgrade\(\) instead`
+			ent.value++
+		})
+	})
+}
+
+func lockInsideRLockDoOtherMutex(ent, other *rwEntity) {
+	ent.locker.RLockDo(func() {
+		other.locker.LockDo(func() {
+			other.value = ent.value
+		})
+	})
+}
+
+func lockInsideLockDo(ent *rwEntity) {
+	ent.locker.LockDo(func() {
+		ent.locker.LockDo(func() {
+			ent.value++
+		})
+	})
+}
+
+func goInsideLockDo(ent *entity) {
+	ent.LockDo(func() {
+		go func() { // want `goroutine started inside ent.LockDo\(\) calls ent.Lock\(\), but the lock is owned by the parent goroutine`
+			ent.Lock()
+			defer ent.Unlock()
+			ent.value++
+		}()
+	})
+}
+
+func goInsideLockDoOtherMutex(ent, other *entity) {
+	ent.LockDo(func() {
+		go other.LockDo(func() {
+			other.value++
+		})
+	})
+}
@@ -0,0 +1,51 @@
+// Package gorex is a stub of the method set of github.com/xaionaro-go/gorex
+// for the tests of the analyzer.
+package gorex
+
+import (
+	"context"
+	"time"
+)
+
+type Mutex struct{ state int }
+
+func (m *Mutex) Lock()                                         {}
+func (m *Mutex) LockTry() bool                                 { return true }
+func (m *Mutex) LockCtx(ctx context.Context) bool              { return true }
+func (m *Mutex) LockTimeout(timeout time.Duration) bool        { return true }
+func (m *Mutex) Unlock()                                       {}
+func (m *Mutex) UnlockCtx(ctx context.Context)                 {}
+func (m *Mutex) LockDo(fn func())                              { fn() }
+func (m *Mutex) LockTryDo(fn func()) bool                      { fn(); return true }
+func (m *Mutex) LockCtxDo(ctx context.Context, fn func()) bool { fn(); return true }
+
+type RWMutex struct{ state int }
+
+func (m *RWMutex) Lock()                                          {}
+func (m *RWMutex) LockTry() bool                                  { return true }
+func (m *RWMutex) LockCtx(ctx context.Context) bool               { return true }
+func (m *RWMutex) LockTimeout(timeout time.Duration) bool         { return true }
+func (m *RWMutex) Unlock()                                        {}
+func (m *RWMutex) UnlockCtx(ctx context.Context)                  {}
+func (m *RWMutex) Downgrade()                                     {}
+func (m *RWMutex) DowngradeCtx(ctx context.Context)               {}
+func (m *RWMutex) LockDo(fn func())                               { fn() }
+func (m *RWMutex) LockTryDo(fn func()) bool                       { fn(); return true }
+func (m *RWMutex) LockCtxDo(ctx context.Context, fn func()) bool  { fn(); return true }
+func (m *RWMutex) RLock()                                         {}
+func (m *RWMutex) RLockTry() bool                                 { return true }
+func (m *RWMutex) RLockCtx(ctx context.Context) bool              { return true }
+func (m *RWMutex) RLockTimeout(timeout time.Duration) bool        { return true }
+func (m *RWMutex) RUnlock()                                       {}
+func (m *RWMutex) RUnlockCtx(ctx context.Context)                 {}
+func (m *RWMutex) RLockDo(fn func())                              { fn() }
+func (m *RWMutex) RLockTryDo(fn func()) bool                      { fn(); return true }
+func (m *RWMutex) RLockCtxDo(ctx context.Context, fn func()) bool { fn(); return true }
+func (m *RWMutex) UpgradeableRLock()                              {}
+func (m *RWMutex) UpgradeableRLockTry() bool                      { return true }
+func (m *RWMutex) UpgradeableRLockCtx(ctx context.Context) bool   { return true }
+func (m *RWMutex) UpgradeableRUnlock()                            {}
+func (m *RWMutex) UpgradeableRUnlockCtx(ctx context.Context)      {}
+func (m *RWMutex) UpgradeableRLockDo(fn func())                   { fn() }
+func (m *RWMutex) Upgrade()                                       {}
+func (m *RWMutex) UpgradeCtx(ctx context.Context) bool            { return true }
@@ -0,0 +1,473 @@
+package gorexcheck
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/cfg"
+)
+
+// lockKind is a kind of a lock acquired by a goroutine.
+type lockKind byte
+
+const (
+	lockKindUndefined = lockKind(iota)
+	lockKindWrite
+	lockKindUpgraded
+	lockKindRead
+	lockKindUpgradeable
+)
+
+// maxLockStackDepth limits the amount of nested acquisitions tracked
+// on a path (to guarantee the termination of the search on loops).
+const maxLockStackDepth = 8
+
+// acquireMethods maps the name of a method to the kind of the lock it acquires.
+var acquireMethods = map[string]lockKind{
+	"Lock":             lockKindWrite,
+	"Upgrade":          lockKindUpgraded,
+	"RLock":            lockKindRead,
+	"UpgradeableRLock": lockKindUpgradeable,
+}
+
+// condAcquireMethods is analog of acquireMethods, but for the methods which
+// acquire the lock only if they return true.
+var condAcquireMethods = map[string]lockKind{
+	"LockTry":             lockKindWrite,
+	"LockCtx":             lockKindWrite,
+	"LockTimeout":         lockKindWrite,
+	"UpgradeCtx":          lockKindUpgraded,
+	"RLockTry":            lockKindRead,
+	"RLockCtx":            lockKindRead,
+	"RLockTimeout":        lockKindRead,
+	"UpgradeableRLockTry": lockKindUpgradeable,
+	"UpgradeableRLockCtx": lockKindUpgradeable,
+}
+
+// isAcquireMethod returns true if the method acquires a lock (conditionally
+// or not).
+func isAcquireMethod(method string) bool {
+	_, isCond := condAcquireMethods[method]
+	return isCond || acquireMethods[method] != lockKindUndefined
+}
+
+// AcquireMethod returns the name of the method which acquires a lock
+// of this kind.
+func (kind lockKind) AcquireMethod() string {
+	switch kind {
+	case lockKindWrite:
+		return "Lock"
+	case lockKindUpgraded:
+		return "Upgrade"
+	case lockKindRead:
+		return "RLock"
+	case lockKindUpgradeable:
+		return "UpgradeableRLock"
+	}
+	return "<undefined>"
+}
+
+// ReleaseMethod returns the name of the method which releases a lock
+// of this kind.
+func (kind lockKind) ReleaseMethod() string {
+	switch kind {
+	case lockKindWrite, lockKindUpgraded:
+		return "Unlock"
+	case lockKindRead:
+		return "RUnlock"
+	case lockKindUpgradeable:
+		return "UpgradeableRUnlock"
+	}
+	return "<undefined>"
+}
+
+// IsReleasedBy returns true if the lock of this kind could be released
+// by the method.
+func (kind lockKind) IsReleasedBy(method string) bool {
+	return method == kind.ReleaseMethod() || (method == "Downgrade" && kind.IsWrite())
+}
+
+// IsWrite returns true if the lock of this kind is a write lock.
+func (kind lockKind) IsWrite() bool {
+	return kind == lockKindWrite || kind == lockKindUpgraded
+}
+
+// releaseMethod returns the name of the method without the context
+// (for example "Unlock" for "UnlockCtx").
+func releaseMethod(method string) string {
+	return strings.TrimSuffix(method, "Ctx")
+}
+
+func isReleaseMethod(method string) bool {
+	switch method {
+	case "Unlock", "RUnlock", "UpgradeableRUnlock", "Downgrade":
+		return true
+	}
+	return false
+}
+
+// unlockChecker checks that the locks acquired within a function are
+// released on all paths with methods of the matching kind.
+type unlockChecker struct {
+	pass     *analysis.Pass
+	deferred []*gorexCall
+	reported map[token.Pos]bool
+
+	// condAcquisitions are the conditional acquisitions by the blocks
+	// they are the condition of (see findCondAcquisitions).
+	condAcquisitions map[*cfg.Block]*condAcquisition
+
+	// conditions is the set of calls of condAcquisitions.
+	conditions map[*ast.CallExpr]bool
+}
+
+// condAcquisition is a call of a method of condAcquireMethods, which
+// result is the condition of a block, for example:
+//
+//	if m.LockTry() {
+//		defer m.Unlock()
+//		...
+//	}
+type condAcquisition struct {
+	call *gorexCall
+	kind lockKind
+
+	// succIdx is the index of the successor of the block, which is
+	// executed if the lock is acquired.
+	succIdx int
+}
+
+func checkUnlocks(pass *analysis.Pass, body *ast.BlockStmt, g *cfg.CFG) {
+	if g == nil {
+		return
+	}
+	c := &unlockChecker{
+		pass:             pass,
+		deferred:         deferredCalls(pass, body),
+		reported:         map[token.Pos]bool{},
+		condAcquisitions: map[*cfg.Block]*condAcquisition{},
+		conditions:       map[*ast.CallExpr]bool{},
+	}
+	c.findCondAcquisitions(body, g)
+	for _, block := range g.Blocks {
+		if !block.Live {
+			continue
+		}
+		if acquisition := c.condAcquisitions[block]; acquisition != nil {
+			c.checkCondAcquisition(block, acquisition)
+		}
+		for nodeIdx, node := range block.Nodes {
+			calls := c.calls(node)
+			for callIdx, call := range calls {
+				kind := acquireMethods[call.Method]
+				if kind == lockKindUndefined {
+					continue
+				}
+				c.checkAcquisition(call, kind, block, nodeIdx, calls[callIdx+1:])
+			}
+		}
+	}
+}
+
+// findCondAcquisitions fills condAcquisitions: the blocks which condition
+// is a call of a method of condAcquireMethods (possibly negated), or
+// a variable assigned with the result of such call, for example:
+//
+//	ok := m.LockTry()
+//	if !ok {
+//		return
+//	}
+func (c *unlockChecker) findCondAcquisitions(body *ast.BlockStmt, g *cfg.CFG) {
+	info := c.pass.TypesInfo
+	results := map[types.Object]*gorexCall{}
+	addResult := func(lhs ast.Expr, rhs ast.Expr) {
+		ident, ok := astutil.Unparen(lhs).(*ast.Ident)
+		if !ok {
+			return
+		}
+		call := asGorexCall(info, rhs)
+		if call == nil {
+			return
+		}
+		if _, ok := condAcquireMethods[call.Method]; !ok {
+			return
+		}
+		if obj := info.ObjectOf(ident); obj != nil {
+			results[obj] = call
+		}
+	}
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			if len(node.Lhs) == 1 && len(node.Rhs) == 1 {
+				addResult(node.Lhs[0], node.Rhs[0])
+			}
+		case *ast.ValueSpec:
+			if len(node.Names) == 1 && len(node.Values) == 1 {
+				addResult(node.Names[0], node.Values[0])
+			}
+		}
+		return true
+	})
+
+	for _, block := range g.Blocks {
+		if !block.Live || len(block.Succs) != 2 || len(block.Nodes) == 0 {
+			continue
+		}
+		cond, ok := block.Nodes[len(block.Nodes)-1].(ast.Expr)
+		if !ok {
+			continue
+		}
+		succIdx := 0 // Succs[0] is the "true" branch
+		for {
+			cond = astutil.Unparen(cond)
+			not, ok := cond.(*ast.UnaryExpr)
+			if !ok || not.Op != token.NOT {
+				break
+			}
+			cond = not.X
+			succIdx = 1 - succIdx
+		}
+		var call *gorexCall
+		switch cond := cond.(type) {
+		case *ast.CallExpr:
+			call = asGorexCall(info, cond)
+		case *ast.Ident:
+			call = results[info.ObjectOf(cond)]
+		}
+		if call == nil {
+			continue
+		}
+		kind, ok := condAcquireMethods[call.Method]
+		if !ok {
+			continue
+		}
+		c.condAcquisitions[block] = &condAcquisition{
+			call:    call,
+			kind:    kind,
+			succIdx: succIdx,
+		}
+		c.conditions[call.Call] = true
+	}
+}
+
+// deferredCalls returns the gorex calls deferred by the function
+// (directly or within a deferred function literal) in the order of execution.
+func deferredCalls(pass *analysis.Pass, body *ast.BlockStmt) []*gorexCall {
+	var deferred [][]*gorexCall
+	ast.Inspect(body, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.DeferStmt:
+			if call := asGorexCall(pass.TypesInfo, node.Call); call != nil {
+				deferred = append(deferred, []*gorexCall{call})
+				return false
+			}
+			if lit, ok := node.Call.Fun.(*ast.FuncLit); ok {
+				var calls []*gorexCall
+				inspectCalls(pass.TypesInfo, lit.Body, func(call *gorexCall) {
+					calls = append(calls, call)
+				})
+				deferred = append(deferred, calls)
+			}
+			return false
+		}
+		return true
+	})
+
+	// deferred functions are called in the reverse order
+	var result []*gorexCall
+	for idx := len(deferred) - 1; idx >= 0; idx-- {
+		result = append(result, deferred[idx]...)
+	}
+	return result
+}
+
+func (c *unlockChecker) calls(node ast.Node) []*gorexCall {
+	var result []*gorexCall
+	inspectCalls(c.pass.TypesInfo, node, func(call *gorexCall) {
+		result = append(result, call)
+	})
+	return result
+}
+
+func (c *unlockChecker) reportf(pos token.Pos, format string, args ...interface{}) {
+	if c.reported[pos] {
+		return
+	}
+	c.reported[pos] = true
+	c.pass.Reportf(pos, format, args...)
+}
+
+// checkAcquisition searches for a path from the acquisition to a return
+// statement, where the acquired lock is not released.
+func (c *unlockChecker) checkAcquisition(
+	acquisition *gorexCall,
+	kind lockKind,
+	block *cfg.Block,
+	nodeIdx int,
+	restCalls []*gorexCall,
+) {
+	s := c.newLockSearch(acquisition.RecvString())
+	stack, isHeld := s.apply([]lockKind{kind}, restCalls)
+	if !isHeld {
+		return
+	}
+	for _, node := range block.Nodes[nodeIdx+1:] {
+		stack, isHeld = s.apply(stack, c.calls(node))
+		if !isHeld {
+			return
+		}
+	}
+	if !s.leave(block, stack) {
+		return
+	}
+	c.reportLeak(acquisition, kind)
+}
+
+// checkCondAcquisition is analog of checkAcquisition, but for a conditional
+// acquisition: the search starts from the branch where the lock is acquired.
+func (c *unlockChecker) checkCondAcquisition(block *cfg.Block, acquisition *condAcquisition) {
+	s := c.newLockSearch(acquisition.call.RecvString())
+	if !s.search(block.Succs[acquisition.succIdx], []lockKind{acquisition.kind}) {
+		return
+	}
+	c.reportLeak(acquisition.call, acquisition.kind)
+}
+
+func (c *unlockChecker) reportLeak(acquisition *gorexCall, kind lockKind) {
+	c.reportf(acquisition.Call.Pos(), "%s() is not followed by %s.%s() on all paths",
+		acquisition, acquisition.RecvString(), kind.ReleaseMethod())
+}
+
+// lockSearch searches for paths where the locks of the mutex "recv"
+// are not released.
+type lockSearch struct {
+	*unlockChecker
+	recv    string
+	visited map[lockSearchKey]bool
+}
+
+type lockSearchKey struct {
+	block *cfg.Block
+	stack string
+}
+
+func (c *unlockChecker) newLockSearch(recv string) *lockSearch {
+	return &lockSearch{
+		unlockChecker: c,
+		recv:          recv,
+		visited:       map[lockSearchKey]bool{},
+	}
+}
+
+// apply updates the stack of held locks according to the calls, and
+// returns false if all the locks are released (or if a lock is released
+// by an unlock of a wrong kind, which is reported right away).
+//
+// It also returns false on a conditional acquisition which result is not
+// a condition of a block (see condAcquisitions): it is unknown if the lock
+// is acquired, so the locks are not tracked any further on this path.
+func (s *lockSearch) apply(stack []lockKind, calls []*gorexCall) ([]lockKind, bool) {
+	for _, call := range calls {
+		if call.RecvString() != s.recv {
+			continue
+		}
+		if kind := acquireMethods[call.Method]; kind != lockKindUndefined {
+			stack = append(stack, kind)
+			continue
+		}
+		if _, ok := condAcquireMethods[call.Method]; ok {
+			if s.conditions[call.Call] {
+				// the lock is pushed on the branch, see leave
+				continue
+			}
+			return nil, false
+		}
+		method := releaseMethod(call.Method)
+		if !isReleaseMethod(method) {
+			continue
+		}
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch {
+		case top.IsReleasedBy(method):
+			if method == "Downgrade" && top == lockKindWrite {
+				stack = append(stack, lockKindRead)
+			}
+		case method == "Downgrade":
+			s.reportf(call.Call.Pos(), "%s() without a write lock acquired", call)
+			return nil, false
+		default:
+			s.reportf(call.Call.Pos(), "%s() releases the lock acquired by %s.%s(), should be %s.%s()",
+				call, s.recv, top.AcquireMethod(), s.recv, top.ReleaseMethod())
+			return nil, false
+		}
+		if len(stack) == 0 {
+			return nil, false
+		}
+	}
+	return stack, true
+}
+
+// isLeaked returns true if some locks are still held after calling
+// the deferred functions.
+func (s *lockSearch) isLeaked(stack []lockKind) bool {
+	_, isHeld := s.apply(append([]lockKind(nil), stack...), s.deferred)
+	return isHeld
+}
+
+// search returns true if there is a path from the beginning of the block
+// to a return statement, where some locks of the stack are not released.
+func (s *lockSearch) search(block *cfg.Block, stack []lockKind) bool {
+	if len(stack) > maxLockStackDepth {
+		return false
+	}
+	key := lockSearchKey{block: block, stack: lockStackString(stack)}
+	if s.visited[key] {
+		return false
+	}
+	s.visited[key] = true
+
+	var isHeld bool
+	for _, node := range block.Nodes {
+		stack, isHeld = s.apply(stack, s.calls(node))
+		if !isHeld {
+			return false
+		}
+	}
+	return s.leave(block, stack)
+}
+
+// leave is analog of search, but starts from the end of the block.
+func (s *lockSearch) leave(block *cfg.Block, stack []lockKind) bool {
+	if block.Return() != nil {
+		return s.isLeaked(stack)
+	}
+	acquisition := s.condAcquisitions[block]
+	for succIdx, succ := range block.Succs {
+		succStack := append([]lockKind(nil), stack...)
+		if acquisition != nil && acquisition.succIdx == succIdx && acquisition.call.RecvString() == s.recv {
+			succStack = append(succStack, acquisition.kind)
+		}
+		if s.search(succ, succStack) {
+			return true
+		}
+	}
+	return false
+}
+
+func lockStackString(stack []lockKind) string {
+	b := make([]byte, len(stack))
+	for idx, kind := range stack {
+		b[idx] = byte(kind)
+	}
+	return string(b)
+}
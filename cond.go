@@ -0,0 +1,238 @@
+package gorex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/xaionaro-go/spinlock"
+)
+
+// Cond is an analog of sync.Cond, but for Mutex and RWMutex.
+//
+// sync.Cond cannot be used with a gorex mutex locked multiple times by
+// the same goroutine: its Wait releases only one level of the lock, so
+// other goroutines are unable to lock the mutex (and to signal) while
+// the goroutine waits. Cond releases the write lock completely for
+// the time of waiting and then restores the same depth.
+type Cond struct {
+	// L is held while observing or changing the condition.
+	// It should be either *Mutex or *RWMutex (the write lock is used).
+	//
+	// Wait panics if the goroutine also holds read locks of the RWMutex
+	// (they would not be released for the time of waiting, so a goroutine
+	// calling Lock to signal would deadlock), except the upgradeable read
+	// lock of an upgraded write lock (see Upgrade): it is kept while
+	// waiting, so it is possible to signal only without the write lock.
+	L sync.Locker
+
+	internalLocker spinlock.Locker
+	waiters        []chan struct{}
+}
+
+// NewCond returns a new Cond with Locker l.
+//
+// l should be either *Mutex or *RWMutex.
+func NewCond(l sync.Locker) *Cond {
+	return &Cond{L: l}
+}
+
+// condLocker is a mutex which could be used with Cond.
+type condLocker interface {
+	sync.Locker
+
+	// condState returns the state of the write lock held by owner "me".
+	// It panics if the lock could not be released for the time of waiting.
+	condState(me GoroutineID) condLockerState
+
+	// unlockAll completely releases the write lock held by owner "me"
+	// (see condState).
+	unlockAll(me GoroutineID, state condLockerState)
+
+	// relock acquires the write lock again and restores the state
+	// returned by condState.
+	relock(me GoroutineID, state condLockerState)
+}
+
+// condLockerState is the state of the write lock of a goroutine, which is
+// saved by Cond for the time of waiting.
+type condLockerState struct {
+	depth        int
+	upgradeDepth int
+}
+
+// Wait atomically unlocks c.L (all the levels of the write lock held by
+// the calling goroutine) and suspends execution of the calling goroutine.
+// After later resuming execution, Wait locks c.L again (with the same depth)
+// before returning. Wait cannot return unless awoken by Broadcast or Signal.
+//
+// See also (*sync.Cond).Wait.
+func (c *Cond) Wait() {
+	c.wait(nil)
+}
+
+// WaitCtx is analog of Wait(), but allows to continue the waiting only until
+// context is done. c.L is locked again on return in any case.
+//
+// If the context carries a lock owner (see WithOwner), then the lock of
+// this owner is released for the time of waiting.
+//
+// Returns `false` if context finished before the goroutine was awoken.
+func (c *Cond) WaitCtx(ctx context.Context) bool {
+	return c.wait(ctx)
+}
+
+func (c *Cond) wait(ctx context.Context) bool {
+	l, ok := c.L.(condLocker)
+	if !ok {
+		panic(fmt.Sprintf("Cond.L is %T, but only *Mutex and *RWMutex are supported.", c.L))
+	}
+
+	// L is checked before the enqueueing, otherwise a panic would leave
+	// a waiter which will never receive the signal.
+	me := lockOwnerID(ctx)
+	state := l.condState(me)
+
+	// The goroutine should be enqueued before unlocking L, otherwise
+	// a Signal between the unlocking and the enqueueing would be lost.
+	ch := make(chan struct{})
+	c.internalLocker.Lock()
+	c.waiters = append(c.waiters, ch)
+	c.internalLocker.Unlock()
+
+	l.unlockAll(me, state)
+	defer l.relock(me, state)
+
+	if ctx == nil {
+		<-ch
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+	}
+	if !c.dequeue(ch) {
+		// Signal was already sent to this goroutine, it should not be lost.
+		return true
+	}
+	return false
+}
+
+// dequeue removes the waiter. Returns false if the waiter was already
+// removed by Signal or Broadcast.
+func (c *Cond) dequeue(ch chan struct{}) bool {
+	c.internalLocker.Lock()
+	defer c.internalLocker.Unlock()
+	for idx, waiter := range c.waiters {
+		if waiter != ch {
+			continue
+		}
+		copy(c.waiters[idx:], c.waiters[idx+1:])
+		c.waiters[len(c.waiters)-1] = nil
+		c.waiters = c.waiters[:len(c.waiters)-1]
+		return true
+	}
+	return false
+}
+
+// Signal wakes one goroutine waiting on c, if there is any (the one
+// which waits for the longest time).
+//
+// It is allowed but not required for the caller to hold c.L
+// during the call.
+func (c *Cond) Signal() {
+	c.internalLocker.Lock()
+	if len(c.waiters) == 0 {
+		c.internalLocker.Unlock()
+		return
+	}
+	ch := c.waiters[0]
+	c.waiters[0] = nil
+	c.waiters = c.waiters[1:]
+	c.internalLocker.Unlock()
+	close(ch)
+}
+
+// Broadcast wakes all goroutines waiting on c.
+//
+// It is allowed but not required for the caller to hold c.L
+// during the call.
+func (c *Cond) Broadcast() {
+	c.internalLocker.Lock()
+	waiters := c.waiters
+	c.waiters = nil
+	c.internalLocker.Unlock()
+	for _, ch := range waiters {
+		close(ch)
+	}
+}
+
+func (m *Mutex) condState(me GoroutineID) condLockerState {
+	m.internalLocker.Lock()
+	switch {
+	case m.monopolizedBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to Wait() on a Cond with a non-locked mutex.")
+	case me != m.monopolizedBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.monopolizedBy))
+	}
+	state := condLockerState{
+		depth: m.monopolizedDepth,
+	}
+	m.internalLocker.Unlock()
+	return state
+}
+
+func (m *Mutex) unlockAll(me GoroutineID, state condLockerState) {
+	for i := 0; i < state.depth; i++ {
+		m.unlock(me)
+	}
+}
+
+func (m *Mutex) relock(me GoroutineID, state condLockerState) {
+	for i := 0; i < state.depth; i++ {
+		m.lock(nil, me, true)
+	}
+}
+
+func (m *RWMutex) condState(me GoroutineID) condLockerState {
+	m.internalLocker.Lock()
+	readersCount := m.readersCountOf(me)
+	if m.upgradeableBy == me {
+		readersCount -= int64(m.upgradeableCount)
+	}
+	switch {
+	case m.lockedBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to Wait() on a Cond with a non-locked mutex.")
+	case me != m.lockedBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.lockedBy))
+	case readersCount != 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to Wait() on a Cond with a mutex, which is also RLock()-ed, call RUnlock() first.")
+	}
+	state := condLockerState{
+		depth:        m.lockCount,
+		upgradeDepth: m.upgradeDepth,
+	}
+	m.internalLocker.Unlock()
+	return state
+}
+
+func (m *RWMutex) unlockAll(me GoroutineID, state condLockerState) {
+	for i := 0; i < state.depth; i++ {
+		m.unlock(me)
+	}
+}
+
+func (m *RWMutex) relock(me GoroutineID, state condLockerState) {
+	for i := 0; i < state.depth; i++ {
+		m.lock(nil, me, true)
+	}
+	m.internalLocker.Lock()
+	m.upgradeDepth = state.upgradeDepth
+	m.internalLocker.Unlock()
+}
@@ -0,0 +1,172 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCond(t *testing.T) {
+	lockers := map[string]func() sync.Locker{
+		"Mutex": func() sync.Locker {
+			return &Mutex{}
+		},
+		"RWMutex": func() sync.Locker {
+			return &RWMutex{}
+		},
+	}
+
+	for name, newLocker := range lockers {
+		newLocker := newLocker
+		t.Run(name, func(t *testing.T) {
+			t.Run("Wait", func(t *testing.T) {
+				l := newLocker()
+				c := NewCond(l)
+				ready := false
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					l.Lock()
+					l.Lock()
+					for !ready {
+						c.Wait()
+					}
+					// the depth is restored
+					l.Unlock()
+					l.Unlock()
+					assert.Panics(t, l.Unlock)
+				}()
+
+				waitForCondWaiters(c, 1)
+				// the lock is released completely while waiting
+				l.Lock()
+				ready = true
+				c.Signal()
+				l.Unlock()
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("the waiter was not awoken")
+				}
+			})
+			t.Run("WaitCtx", func(t *testing.T) {
+				l := newLocker()
+				c := NewCond(l)
+				l.Lock()
+				l.Lock()
+				ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+				defer cancelFn()
+				assert.False(t, c.WaitCtx(ctx))
+				assert.Empty(t, c.waiters)
+				l.Unlock()
+				l.Unlock()
+				assert.Panics(t, l.Unlock)
+			})
+			t.Run("Signal", func(t *testing.T) {
+				l := newLocker()
+				c := NewCond(l)
+				awoken := make(chan struct{}, 2)
+				for i := 0; i < 2; i++ {
+					go func() {
+						l.Lock()
+						defer l.Unlock()
+						c.Wait()
+						awoken <- struct{}{}
+					}()
+				}
+				waitForCondWaiters(c, 2)
+
+				c.Signal()
+				<-awoken
+				select {
+				case <-awoken:
+					t.Fatal("Signal awoke more than one goroutine")
+				case <-time.After(10 * time.Millisecond):
+				}
+				c.Signal()
+				<-awoken
+			})
+			t.Run("not_locked", func(t *testing.T) {
+				l := newLocker()
+				c := NewCond(l)
+				assert.Panics(t, c.Wait)
+				assert.Empty(t, c.waiters)
+
+				l.Lock()
+				inGoroutine := make(chan struct{})
+				go func() {
+					defer close(inGoroutine)
+					assert.Panics(t, c.Wait)
+				}()
+				<-inGoroutine
+				l.Unlock()
+				assert.Empty(t, c.waiters)
+			})
+			t.Run("Broadcast", func(t *testing.T) {
+				l := newLocker()
+				c := NewCond(l)
+				var wg sync.WaitGroup
+				for i := 0; i < 10; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						l.Lock()
+						defer l.Unlock()
+						c.Wait()
+					}()
+				}
+				waitForCondWaiters(c, 10)
+				c.Broadcast()
+				wg.Wait()
+			})
+		})
+	}
+
+	t.Run("RWMutex_Upgrade", func(t *testing.T) {
+		l := &RWMutex{}
+		c := NewCond(l)
+		l.UpgradeableRLock()
+		l.Upgrade()
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancelFn()
+		assert.False(t, c.WaitCtx(ctx))
+		// the write lock is still the upgraded one
+		l.Downgrade()
+		l.UpgradeableRUnlock()
+		assert.True(t, l.LockTry())
+		l.Unlock()
+	})
+
+	t.Run("RWMutex_RLock", func(t *testing.T) {
+		l := &RWMutex{}
+		c := NewCond(l)
+		l.RLock()
+		l.Lock()
+		assert.Panics(t, c.Wait)
+		assert.Empty(t, c.waiters)
+		l.Unlock()
+		l.RUnlock()
+		assert.True(t, l.LockTry())
+		l.Unlock()
+	})
+
+	t.Run("unsupported_locker", func(t *testing.T) {
+		c := NewCond(&sync.Mutex{})
+		assert.Panics(t, c.Wait)
+	})
+}
+
+func waitForCondWaiters(c *Cond, count int) {
+	for {
+		c.internalLocker.Lock()
+		waitersCount := len(c.waiters)
+		c.internalLocker.Unlock()
+		if waitersCount >= count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
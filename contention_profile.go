@@ -0,0 +1,179 @@
+package gorex
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// contentionProfileStackDepth is the maximal depth of stack traces
+	// of the contention profile.
+	contentionProfileStackDepth = 32
+)
+
+var contentionProfileFraction int64
+
+// SetContentionProfileFraction controls the fraction of waits on gorex
+// mutexes (Mutex and RWMutex, write and read locks) that are reported
+// in the contention profile (see WriteContentionProfile). On average 1/rate
+// waits are reported. The previous rate is returned.
+//
+// It is an analog of runtime.SetMutexProfileFraction: gorex mutexes block
+// on channels, so their contention is not attributed properly by the
+// built-in mutex profile.
+//
+// To turn off profiling entirely, pass rate 0. To just read the current
+// rate, pass rate < 0.
+func SetContentionProfileFraction(rate int) int {
+	if rate < 0 {
+		return int(atomic.LoadInt64(&contentionProfileFraction))
+	}
+	return int(atomic.SwapInt64(&contentionProfileFraction, int64(rate)))
+}
+
+type contentionProfileKey [contentionProfileStackDepth]uintptr
+
+type contentionProfileRecord struct {
+	count int64
+	delay time.Duration
+}
+
+type contentionProfile struct {
+	locker    sync.Mutex
+	records   map[contentionProfileKey]*contentionProfileRecord
+	startedAt time.Time
+}
+
+var globalContentionProfile = contentionProfile{
+	records:   map[contentionProfileKey]*contentionProfileRecord{},
+	startedAt: time.Now(),
+}
+
+// contentionWaitStart remembers the moment the goroutine started to wait
+// for a lock (if the contention profiling is enabled and the moment is not
+// remembered, yet).
+func contentionWaitStart(waitStartedAt *time.Time) {
+	if !waitStartedAt.IsZero() {
+		return
+	}
+	if atomic.LoadInt64(&contentionProfileFraction) <= 0 {
+		return
+	}
+	*waitStartedAt = time.Now()
+}
+
+// contentionRecord adds the wait (started at "waitStartedAt", see
+// contentionWaitStart) to the contention profile (if sampled).
+func contentionRecord(waitStartedAt time.Time) {
+	if waitStartedAt.IsZero() {
+		return
+	}
+	delay := time.Since(waitStartedAt)
+	rate := atomic.LoadInt64(&contentionProfileFraction)
+	if rate <= 0 {
+		return
+	}
+	if rate > 1 && rand.Int63n(rate) != 0 {
+		return
+	}
+
+	var key contentionProfileKey
+	runtime.Callers(2, key[:])
+
+	p := &globalContentionProfile
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	record := p.records[key]
+	if record == nil {
+		record = &contentionProfileRecord{}
+		p.records[key] = record
+	}
+	record.count += rate
+	record.delay += delay * time.Duration(rate)
+}
+
+// WriteContentionProfile writes the contention profile of gorex mutexes
+// (see SetContentionProfileFraction) to "out".
+//
+// If debug is zero, then the profile is written in the gzip-compressed
+// protocol buffer format, which is supported by "go tool pprof". Otherwise
+// a human-readable text is written. It is the same convention as
+// used by (*pprof.Profile).WriteTo.
+func WriteContentionProfile(out io.Writer, debug int) error {
+	p := &globalContentionProfile
+	p.locker.Lock()
+	samples := make([]contentionProfileSample, 0, len(p.records))
+	for key, record := range p.records {
+		samples = append(samples, contentionProfileSample{
+			stack: stackOfKey(key),
+			count: record.count,
+			delay: record.delay,
+		})
+	}
+	startedAt := p.startedAt
+	p.locker.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].delay > samples[j].delay
+	})
+
+	if debug == 0 {
+		rate := atomic.LoadInt64(&contentionProfileFraction)
+		return writeContentionProfileProto(out, samples, startedAt, rate)
+	}
+	return writeContentionProfileText(out, samples)
+}
+
+// ResetContentionProfile discards all the collected contention samples.
+func ResetContentionProfile() {
+	p := &globalContentionProfile
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	p.records = map[contentionProfileKey]*contentionProfileRecord{}
+	p.startedAt = time.Now()
+}
+
+type contentionProfileSample struct {
+	stack []uintptr
+	count int64
+	delay time.Duration
+}
+
+func stackOfKey(key contentionProfileKey) []uintptr {
+	for idx, pc := range key {
+		if pc == 0 {
+			return append([]uintptr(nil), key[:idx]...)
+		}
+	}
+	return append([]uintptr(nil), key[:]...)
+}
+
+func writeContentionProfileText(out io.Writer, samples []contentionProfileSample) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "--- gorex contention:\n")
+	tw := tabwriter.NewWriter(w, 1, 8, 1, ' ', 0)
+	fmt.Fprintf(tw, "delay\tcontentions\n")
+	for _, sample := range samples {
+		fmt.Fprintf(tw, "%v\t%d\n", sample.delay, sample.count)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		fmt.Fprintf(w, "\n%v %d @", sample.delay, sample.count)
+		for _, pc := range sample.stack {
+			fmt.Fprintf(w, " %#x", pc)
+		}
+		fmt.Fprintf(w, "\n")
+		printStack(w, sample.stack)
+	}
+	return w.Flush()
+}
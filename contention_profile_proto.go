@@ -0,0 +1,220 @@
+package gorex
+
+import (
+	"compress/gzip"
+	"io"
+	"runtime"
+	"time"
+)
+
+// protoBuffer is a minimal encoder of the protocol buffers wire format,
+// enough to write profiles in the format of
+// https://github.com/google/pprof/blob/master/proto/profile.proto
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.data = append(b.data, byte(v)|0x80)
+		v >>= 7
+	}
+	b.data = append(b.data, byte(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) int64(field int, v int64) {
+	b.uint64(field, uint64(v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(v)))
+	b.data = append(b.data, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+func (b *protoBuffer) message(field int, fn func(msg *protoBuffer)) {
+	var msg protoBuffer
+	fn(&msg)
+	b.bytes(field, msg.data)
+}
+
+func (b *protoBuffer) packedUint64s(field int, vs []uint64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	b.bytes(field, packed.data)
+}
+
+func (b *protoBuffer) packedInt64s(field int, vs []int64) {
+	var packed protoBuffer
+	for _, v := range vs {
+		packed.varint(uint64(v))
+	}
+	b.bytes(field, packed.data)
+}
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID      = 1
+	locationAddress = 3
+	locationLine    = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+)
+
+type profileBuilder struct {
+	profile     protoBuffer
+	strings     []string
+	stringIndex map[string]int64
+	locations   map[uintptr]uint64
+	functions   map[string]uint64
+}
+
+func newProfileBuilder() *profileBuilder {
+	return &profileBuilder{
+		strings:     []string{""},
+		stringIndex: map[string]int64{"": 0},
+		locations:   map[uintptr]uint64{},
+		functions:   map[string]uint64{},
+	}
+}
+
+func (b *profileBuilder) stringID(s string) int64 {
+	if id, ok := b.stringIndex[s]; ok {
+		return id
+	}
+	id := int64(len(b.strings))
+	b.strings = append(b.strings, s)
+	b.stringIndex[s] = id
+	return id
+}
+
+func (b *profileBuilder) valueType(field int, typ, unit string) {
+	b.profile.message(field, func(msg *protoBuffer) {
+		msg.int64(valueTypeType, b.stringID(typ))
+		msg.int64(valueTypeUnit, b.stringID(unit))
+	})
+}
+
+func (b *profileBuilder) functionID(frame runtime.Frame) uint64 {
+	if id, ok := b.functions[frame.Function]; ok {
+		return id
+	}
+	id := uint64(len(b.functions) + 1)
+	b.functions[frame.Function] = id
+	b.profile.message(profileFunction, func(msg *protoBuffer) {
+		msg.uint64(functionID, id)
+		msg.int64(functionName, b.stringID(frame.Function))
+		msg.int64(functionSystemName, b.stringID(frame.Function))
+		msg.int64(functionFilename, b.stringID(frame.File))
+	})
+	return id
+}
+
+func (b *profileBuilder) locationID(pc uintptr) uint64 {
+	if id, ok := b.locations[pc]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[pc] = id
+
+	// a PC may correspond to multiple frames due to inlining
+	type line struct {
+		functionID uint64
+		line       int64
+	}
+	var lines []line
+	frames := runtime.CallersFrames([]uintptr{pc})
+	for {
+		frame, more := frames.Next()
+		lines = append(lines, line{
+			functionID: b.functionID(frame),
+			line:       int64(frame.Line),
+		})
+		if !more {
+			break
+		}
+	}
+
+	b.profile.message(profileLocation, func(msg *protoBuffer) {
+		msg.uint64(locationID, id)
+		msg.uint64(locationAddress, uint64(pc))
+		for _, l := range lines {
+			msg.message(locationLine, func(lineMsg *protoBuffer) {
+				lineMsg.uint64(lineFunctionID, l.functionID)
+				lineMsg.int64(lineLine, l.line)
+			})
+		}
+	})
+	return id
+}
+
+func writeContentionProfileProto(
+	out io.Writer,
+	samples []contentionProfileSample,
+	startedAt time.Time,
+	rate int64,
+) error {
+	b := newProfileBuilder()
+	b.valueType(profileSampleType, "contentions", "count")
+	b.valueType(profileSampleType, "delay", "nanoseconds")
+	for _, sample := range samples {
+		locationIDs := make([]uint64, 0, len(sample.stack))
+		for _, pc := range sample.stack {
+			locationIDs = append(locationIDs, b.locationID(pc))
+		}
+		b.profile.message(profileSample, func(msg *protoBuffer) {
+			msg.packedUint64s(sampleLocationID, locationIDs)
+			msg.packedInt64s(sampleValue, []int64{sample.count, int64(sample.delay)})
+		})
+	}
+	now := time.Now()
+	b.profile.int64(profileTimeNanos, startedAt.UnixNano())
+	b.profile.int64(profileDurationNanos, int64(now.Sub(startedAt)))
+	b.valueType(profilePeriodType, "contentions", "count")
+	b.profile.int64(profilePeriod, rate)
+	for _, s := range b.strings {
+		b.profile.string(profileStringTable, s)
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(b.profile.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
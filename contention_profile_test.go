@@ -0,0 +1,76 @@
+package gorex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentionProfile(t *testing.T) {
+	oldRate := SetContentionProfileFraction(1)
+	defer SetContentionProfileFraction(oldRate)
+	ResetContentionProfile()
+	defer ResetContentionProfile()
+
+	mutex := &Mutex{}
+	rwMutex := &RWMutex{}
+	var wg0, wg1 sync.WaitGroup
+	wg0.Add(1)
+	wg1.Add(1)
+	go mutex.LockDo(func() {
+		rwMutex.LockDo(func() {
+			wg1.Done()
+			wg0.Wait()
+		})
+	})
+	wg1.Wait()
+	time.AfterFunc(10*time.Millisecond, wg0.Done)
+	mutex.LockDo(func() {})
+	rwMutex.RLockDo(func() {})
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, WriteContentionProfile(&buf, 1))
+		text := buf.String()
+		assert.True(t, strings.Contains(text, "gorex.(*Mutex).lock"), text)
+		assert.True(t, strings.Contains(text, "gorex.TestContentionProfile"), text)
+	})
+	t.Run("proto", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, WriteContentionProfile(&buf, 0))
+		r, err := gzip.NewReader(&buf)
+		if !assert.NoError(t, err) {
+			return
+		}
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Contains(b, []byte("contentions")))
+		assert.True(t, bytes.Contains(b, []byte("gorex.TestContentionProfile")))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		SetContentionProfileFraction(0)
+		defer SetContentionProfileFraction(1)
+		ResetContentionProfile()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		mutex.Lock()
+		go func() {
+			defer wg.Done()
+			mutex.LockDo(func() {})
+		}()
+		time.Sleep(time.Millisecond)
+		mutex.Unlock()
+		wg.Wait()
+
+		var buf bytes.Buffer
+		assert.NoError(t, WriteContentionProfile(&buf, 1))
+		assert.False(t, strings.Contains(buf.String(), "@"), buf.String())
+	})
+}
@@ -4,12 +4,12 @@
 package gorex
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"runtime"
 	"sync"
-
-	"github.com/huandu/go-tls"
+	"time"
 )
 
 type debuggerLockerKey struct {
@@ -17,79 +17,199 @@ type debuggerLockerKey struct {
 	IsWrite   bool
 }
 
-type debuggerPcs struct {
-	pcs *[]uintptr
-	n   int
-}
-
 type debuggerGStorage struct {
-	PCS map[debuggerLockerKey]debuggerPcs
+	PCS map[debuggerLockerKey][]uintptr
 }
 
+// The Go runtime has no hook on a goroutine exit (and a finalizer could not
+// be set on a goroutine), so the only way to find out if a goroutine exited is
+// to look for it in the dump of all goroutines (see getAliveGoroutineIDs),
+// which stops the world. Therefore, the goroutines holding locks are checked
+// periodically (see debuggerWatch), and the checks are made less frequent
+// the longer the locks are held and the more expensive the dump is.
 var (
-	debuggerMap = &sync.Map{}
-	exitC       chan struct{}
-)
+	debuggerLocker  sync.Mutex
+	debuggerStorage = map[GoroutineID]*debuggerGStorage{}
 
-func getOrStoreDebuggerGStorage() *debuggerGStorage {
-	stor, _ := debuggerMap.LoadOrStore(GetG(), &debuggerGStorage{})
-	return stor.(*debuggerGStorage)
-}
+	// debuggerIsWatching is true while debuggerWatch is running (it is
+	// guarded by debuggerLocker).
+	debuggerIsWatching bool
+
+	// debuggerCheckInterval is how often goroutines holding locks
+	// are checked for being exited right after the first lock is opened.
+	debuggerCheckInterval = 10 * time.Millisecond
+
+	// debuggerMaxCheckInterval is the maximal interval between the checks,
+	// the interval is doubled after every check until it reaches this value.
+	debuggerMaxCheckInterval = time.Second
+
+	// debuggerCheckCostFactor is the minimal ratio of the interval between
+	// the checks to the duration of a check (so the checks take at most
+	// 1% of the time even if there are a lot of goroutines).
+	debuggerCheckCostFactor = 100
+)
 
 func getDebuggerLockerKey(lockPtr sync.Locker, isWrite bool) debuggerLockerKey {
 	return debuggerLockerKey{
-		LockerPtr: reflect.ValueOf(lockPtr).Elem().UnsafeAddr(),
+		LockerPtr: reflect.ValueOf(lockPtr).Pointer(),
 		IsWrite:   isWrite,
 	}
 }
 
-func goroutineCheckOnExit() {
-	stor := getOrStoreDebuggerGStorage()
-	for lKey, pcs := range stor.PCS {
-		frames := runtime.CallersFrames((*pcs.pcs)[:pcs.n])
-		fmt.Fprintf(debugPanicOut, "an opened lock %+v which was never released (and the goroutine already exited):\n",
-			lKey)
-		printFrames(frames)
+// getAliveGoroutineIDs returns the IDs of all existing goroutines. "buf" is
+// the buffer for the dump of the goroutines, it is reused between the calls.
+func getAliveGoroutineIDs(buf *[]byte) map[GoroutineID]struct{} {
+	if *buf == nil {
+		*buf = make([]byte, 1024*1024)
+	}
+	var dump []byte
+	for {
+		n := runtime.Stack(*buf, true)
+		if n < len(*buf) {
+			dump = (*buf)[:n]
+			break
+		}
+		*buf = make([]byte, len(*buf)*2)
 	}
-	debuggerMap.Delete(GetG())
 
-	if exitC != nil {
-		close(exitC)
-		exitC = nil
+	// only the headers ("goroutine 123 [running]:") are needed, so
+	// the stacks are not parsed (see parseGoroutineStacks)
+	const prefix = "goroutine "
+	result := map[GoroutineID]struct{}{}
+	for len(dump) > 0 {
+		if bytes.HasPrefix(dump, []byte(prefix)) {
+			var id GoroutineID
+			for _, c := range dump[len(prefix):] {
+				if c < '0' || c > '9' {
+					break
+				}
+				id = id*10 + GoroutineID(c-'0')
+			}
+			result[id] = struct{}{}
+		}
+		idx := bytes.Index(dump, []byte("\n\n"))
+		if idx < 0 {
+			break
+		}
+		dump = dump[idx+2:]
 	}
+	return result
 }
 
-var pcsPool = sync.Pool{New: func() interface{} {
-	pcs := make([]uintptr, 128)
-	return &pcs
-}}
+// debuggerWatch periodically checks the goroutines holding locks (see
+// goroutineCheckExited) until no goroutine holds a lock, so the (expensive)
+// dump of all goroutines is not taken while there is nothing to check.
+// It is started again by goroutineOpenedLock.
+func debuggerWatch() {
+	interval := debuggerCheckInterval
+	var buf []byte
+	for {
+		time.Sleep(interval)
+		startedAt := time.Now()
+		if !goroutineCheckExited(&buf) {
+			return
+		}
+		interval = nextDebuggerCheckInterval(interval, time.Since(startedAt))
+	}
+}
 
-func goroutineOpenedLock(lockPtr sync.Locker, isWrite bool) {
-	pcs := pcsPool.Get().(*[]uintptr)
-	n := runtime.Callers(2, *pcs)
+// nextDebuggerCheckInterval returns the interval before the next check
+// (see debuggerWatch) given the previous interval and the duration of
+// the last check.
+func nextDebuggerCheckInterval(interval, checkDuration time.Duration) time.Duration {
+	interval *= 2
+	if interval > debuggerMaxCheckInterval {
+		interval = debuggerMaxCheckInterval
+	}
+	if minInterval := checkDuration * time.Duration(debuggerCheckCostFactor); interval < minInterval {
+		interval = minInterval
+	}
+	return interval
+}
 
-	stor, lKey := getOrStoreDebuggerGStorage(), getDebuggerLockerKey(lockPtr, isWrite)
-	if _, found := stor.PCS[lKey]; found {
-		panic("should not happen")
+// goroutineCheckExited reports the locks which were never released by
+// already exited goroutines.
+//
+// Returns false (and marks the watching as stopped, see debuggerWatch)
+// if no goroutine holds a lock.
+func goroutineCheckExited(buf *[]byte) bool {
+	debuggerLocker.Lock()
+	if len(debuggerStorage) == 0 {
+		debuggerIsWatching = false
+		debuggerLocker.Unlock()
+		return false
 	}
-	if stor.PCS == nil {
-		stor.PCS = map[debuggerLockerKey]debuggerPcs{}
+	// only the goroutines which already existed before the dump could be
+	// checked (a new goroutine could open a lock while the dump is parsed)
+	holders := make([]GoroutineID, 0, len(debuggerStorage))
+	for g := range debuggerStorage {
+		holders = append(holders, g)
 	}
-	stor.PCS[lKey] = debuggerPcs{pcs: pcs, n: n}
+	debuggerLocker.Unlock()
+
+	alive := getAliveGoroutineIDs(buf)
+
+	debuggerLocker.Lock()
+	defer debuggerLocker.Unlock()
+	for _, g := range holders {
+		if _, isAlive := alive[g]; isAlive {
+			continue
+		}
+		stor := debuggerStorage[g]
+		if stor == nil {
+			continue
+		}
+		for lKey, pcs := range stor.PCS {
+			fmt.Fprintf(debugPanicOut, "an opened lock %+v which was never released (and the goroutine %d already exited):\n",
+				lKey, g)
+			printFrames(debugPanicOut, runtime.CallersFrames(pcs))
+		}
+		delete(debuggerStorage, g)
+	}
+	return true
+}
 
-	_, ok := tls.Get("gorex-debugger")
-	if ok {
+func goroutineOpenedLock(lockPtr sync.Locker, me GoroutineID, isWrite bool) {
+	if me&virtualOwnerIDBit != 0 {
+		// the lock is owned by a context (see WithOwner), not by a goroutine
 		return
 	}
-	tls.AtExit(goroutineCheckOnExit)
-	tls.Set("gorex-debugger", nil)
+	pcs := callers(1, 128)
+	lKey := getDebuggerLockerKey(lockPtr, isWrite)
+
+	debuggerLocker.Lock()
+	defer debuggerLocker.Unlock()
+	if !debuggerIsWatching {
+		debuggerIsWatching = true
+		go debuggerWatch()
+	}
+	stor := debuggerStorage[me]
+	if stor == nil {
+		stor = &debuggerGStorage{
+			PCS: map[debuggerLockerKey][]uintptr{},
+		}
+		debuggerStorage[me] = stor
+	}
+	if _, found := stor.PCS[lKey]; found {
+		panic("should not happen")
+	}
+	stor.PCS[lKey] = pcs
 }
 
-func goroutineClosedLock(lockPtr sync.Locker, isWrite bool) {
-	stor, lKey := getOrStoreDebuggerGStorage(), getDebuggerLockerKey(lockPtr, isWrite)
-	pcs := stor.PCS[lKey]
-	if pcs.pcs != nil {
-		pcsPool.Put(pcs.pcs)
+func goroutineClosedLock(lockPtr sync.Locker, me GoroutineID, isWrite bool) {
+	if me&virtualOwnerIDBit != 0 {
+		return
+	}
+	lKey := getDebuggerLockerKey(lockPtr, isWrite)
+
+	debuggerLocker.Lock()
+	defer debuggerLocker.Unlock()
+	stor := debuggerStorage[me]
+	if stor == nil {
+		return
 	}
 	delete(stor.PCS, lKey)
+	if len(stor.PCS) == 0 {
+		delete(debuggerStorage, me)
+	}
 }
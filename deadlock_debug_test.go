@@ -4,58 +4,139 @@
 package gorex
 
 import (
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
 type writeWaiter struct {
-	c chan struct{}
+	c    chan struct{}
+	once sync.Once
 }
 
 func (w *writeWaiter) Write(b []byte) (int, error) {
-	close(w.c)
+	w.once.Do(func() {
+		close(w.c)
+	})
 	return len(b), nil
 }
 
+func setDebugPanicOut(waiter *writeWaiter) {
+	debuggerLocker.Lock()
+	defer debuggerLocker.Unlock()
+	debugPanicOut = waiter
+}
+
 func TestDeadlockDebug(t *testing.T) {
+	leakingLockers := map[string]func(){
+		"(*Mutex).Lock": func() {
+			locker := &Mutex{}
+			locker.Lock()
+		},
+		"(*RWMutex).Lock": func() {
+			locker := &RWMutex{}
+			locker.Lock()
+		},
+		"(*RWMutex).RLock": func() {
+			locker := &RWMutex{}
+			locker.RLock()
+		},
+	}
+	releasingLockers := map[string]func(){
+		"(*Mutex).Lock&Unlock": func() {
+			locker := &Mutex{}
+			locker.Lock()
+			locker.Unlock()
+		},
+		"(*RWMutex).Lock&Unlock": func() {
+			locker := &RWMutex{}
+			locker.Lock()
+			locker.Unlock()
+		},
+		"(*RWMutex).RLock&RUnlock": func() {
+			locker := &RWMutex{}
+			locker.RLock()
+			locker.RUnlock()
+			locker.RLock()
+			locker.RUnlock()
+		},
+	}
+
 	t.Run("positive", func(t *testing.T) {
-		t.Run("(*Mutex).Lock", func(t *testing.T) {
-			exitC = make(chan struct{})
-			waiter := writeWaiter{c: make(chan struct{})}
-			debugPanicOut = &waiter
-			go func() {
-				locker := &Mutex{}
-				locker.Lock()
-			}()
-
-			time.Sleep(time.Microsecond)
-			select {
-			case <-waiter.c:
-			case <-time.After(time.Millisecond):
-				t.Errorf("no debug info received :(")
-			case <-exitC:
-				t.Errorf("no debug info received :(")
-			}
-		})
+		for name, fn := range leakingLockers {
+			fn := fn
+			t.Run(name, func(t *testing.T) {
+				waiter := &writeWaiter{c: make(chan struct{})}
+				setDebugPanicOut(waiter)
+				go fn()
+
+				select {
+				case <-waiter.c:
+				case <-time.After(5 * debuggerMaxCheckInterval):
+					t.Errorf("no debug info received :(")
+				}
+			})
+		}
 	})
 	t.Run("negative", func(t *testing.T) {
-		t.Run("(*Mutex).Lock&Unlock", func(t *testing.T) {
-			exitC = make(chan struct{})
-			waiter := writeWaiter{c: make(chan struct{})}
-			debugPanicOut = &waiter
-			go func() {
-				locker := &Mutex{}
-				locker.Lock()
-				locker.Unlock()
-			}()
-
-			time.Sleep(time.Microsecond)
-			select {
-			case <-waiter.c:
-				t.Errorf("received debug info, while shouldn't")
-			case <-time.After(time.Millisecond):
-			case <-exitC:
-			}
+		for name, fn := range releasingLockers {
+			fn := fn
+			t.Run(name, func(t *testing.T) {
+				waiter := &writeWaiter{c: make(chan struct{})}
+				setDebugPanicOut(waiter)
+				go fn()
+
+				select {
+				case <-waiter.c:
+					t.Errorf("received debug info, while shouldn't")
+				case <-time.After(5 * debuggerCheckInterval):
+				}
+			})
+		}
+	})
+	t.Run("getAliveGoroutineIDs", func(t *testing.T) {
+		var buf []byte
+		alive := getAliveGoroutineIDs(&buf)
+		assert.Contains(t, alive, GetGoroutineID())
+
+		exited := make(chan GoroutineID)
+		go func() {
+			exited <- GetGoroutineID()
+		}()
+		g := <-exited
+		assert.Eventually(t, func() bool {
+			_, isAlive := getAliveGoroutineIDs(&buf)[g]
+			return !isAlive
+		}, time.Second, time.Millisecond)
+	})
+	t.Run("nextDebuggerCheckInterval", func(t *testing.T) {
+		assert.Equal(t, 2*debuggerCheckInterval, nextDebuggerCheckInterval(debuggerCheckInterval, 0))
+		assert.Equal(t, debuggerMaxCheckInterval, nextDebuggerCheckInterval(debuggerMaxCheckInterval, 0))
+		// an expensive check makes the next one to happen later
+		assert.Equal(t, 100*debuggerCheckInterval, nextDebuggerCheckInterval(debuggerCheckInterval, debuggerCheckInterval))
+	})
+	t.Run("stopWatching", func(t *testing.T) {
+		locker := &Mutex{}
+		locker.LockDo(func() {
+			debuggerLocker.Lock()
+			defer debuggerLocker.Unlock()
+			assert.True(t, debuggerIsWatching)
 		})
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			debuggerLocker.Lock()
+			isWatching := debuggerIsWatching
+			debuggerLocker.Unlock()
+			if !isWatching {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("the goroutines are still checked while no lock is held")
+			}
+			time.Sleep(debuggerCheckInterval)
+		}
 	})
 }
@@ -7,5 +7,5 @@ import (
 	"sync"
 )
 
-func goroutineOpenedLock(lockPtr sync.Locker, isWrite bool) {}
-func goroutineClosedLock(lockPtr sync.Locker, isWrite bool) {}
+func goroutineOpenedLock(lockPtr sync.Locker, me GoroutineID, isWrite bool) {}
+func goroutineClosedLock(lockPtr sync.Locker, me GoroutineID, isWrite bool) {}
@@ -1,34 +1,232 @@
 package gorex
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"sort"
+	"sync"
+	"time"
 	_ "unsafe" // for "go:linkname"
 )
 
 var debugPanicOut = io.Writer(os.Stderr)
 
-func debugPanic(
-	monopolizedBy GoroutineID,
+// OnDeadlock is called when the InfiniteContext of a Mutex/RWMutex is done
+// (see DefaultInfiniteContext) and the mutex does not have its own OnDeadlock
+// handler.
+//
+// If the handler returns then the goroutine continues to wait for the lock
+// (without the InfiniteContext). The zero-value means to print the report
+// to stderr and panic.
+var OnDeadlock func(*DeadlockReport)
+
+// DeadlockReport is the debugging information about a mutex which was not
+// able to be locked before the InfiniteContext is done.
+type DeadlockReport struct {
+	// Locker is the mutex (*Mutex or *RWMutex).
+	Locker sync.Locker `json:"-"`
+
+	// Semaphore is the semaphore if the report is about a Semaphore
+	// (instead of a mutex).
+	Semaphore *Semaphore `json:"-"`
+
+	// Owner is the ID of goroutine which holds the (write) lock, or zero.
+	Owner GoroutineID
+
+	// OwnerStack is the call stack trace where Owner acquired the lock.
+	OwnerStack []StackFrame `json:",omitempty"`
+
+	// Readers is the list of goroutines which hold a read lock.
+	Readers []DeadlockReader `json:",omitempty"`
+
+	// Holders is the list of goroutines which hold permits of
+	// the semaphore (see Semaphore).
+	Holders []DeadlockHolder `json:",omitempty"`
+
+	// Waiters is the list of goroutines which wait for the lock.
+	Waiters []DeadlockWaiter
+
+	// Goroutines is the call stack traces of all goroutines.
+	Goroutines []GoroutineStack
+
+	rawStacks []byte
+}
+
+// DeadlockReader is a goroutine which holds a read lock.
+type DeadlockReader struct {
+	// GoroutineID is the ID of the goroutine.
+	GoroutineID GoroutineID
+
+	// Count is how many times the read lock is acquired by the goroutine.
+	Count int64
+
+	// Stack is the call stack trace where the goroutine acquired
+	// the read lock.
+	Stack []StackFrame `json:",omitempty"`
+}
+
+// DeadlockHolder is a goroutine which holds permits of a Semaphore.
+type DeadlockHolder struct {
+	// GoroutineID is the ID of the goroutine.
+	GoroutineID GoroutineID
+
+	// Permits is how many permits are held by the goroutine.
+	Permits int64
+
+	// Depth is how many times the permits are acquired by the goroutine.
+	Depth int
+
+	// Stack is the call stack trace where the goroutine acquired
+	// the permits.
+	Stack []StackFrame `json:",omitempty"`
+}
+
+// DeadlockWaiter is a goroutine which waits for a lock.
+type DeadlockWaiter struct {
+	// GoroutineID is the ID of the goroutine.
+	GoroutineID GoroutineID
+
+	// IsWrite is true if the goroutine waits for a write lock.
+	IsWrite bool
+
+	// Permits is how many permits the goroutine waits for (if it waits
+	// for a Semaphore).
+	Permits int64 `json:",omitempty"`
+
+	// Since is the moment the goroutine started to wait.
+	Since time.Time
+
+	// Stack is the call stack trace where the goroutine started to wait
+	// (see SetAcquisitionStackDepth).
+	Stack []StackFrame `json:",omitempty"`
+}
+
+// WriteTo writes a human-readable summary of the report (without
+// stack traces of all goroutines) to "out".
+func (report *DeadlockReport) WriteTo(out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	if report.Owner != 0 {
+		fmt.Fprintf(&buf, "The lock is monopolized by goroutine %d.\n", report.Owner)
+	}
+	if len(report.OwnerStack) > 0 {
+		fmt.Fprintf(&buf, "The lock was acquired at:\n")
+		for _, frame := range report.OwnerStack {
+			fmt.Fprintf(&buf, "\t%s\n", frame)
+		}
+	}
+
+	if len(report.Readers) > 0 {
+		fmt.Fprintf(&buf, "There are %d goroutines holding a read lock on the locker:\n", len(report.Readers))
+		for idx, reader := range report.Readers {
+			fmt.Fprintf(&buf, "\t%d. %d reader-locks by goroutine %d.\n", idx+1, reader.Count, reader.GoroutineID)
+			for _, frame := range reader.Stack {
+				fmt.Fprintf(&buf, "\t\t%s\n", frame)
+			}
+		}
+	}
+
+	if len(report.Holders) > 0 {
+		fmt.Fprintf(&buf, "There are %d goroutines holding permits of the semaphore:\n", len(report.Holders))
+		for idx, holder := range report.Holders {
+			fmt.Fprintf(&buf, "\t%d. %d permits (acquired %d times) by goroutine %d.\n", idx+1, holder.Permits, holder.Depth, holder.GoroutineID)
+			for _, frame := range holder.Stack {
+				fmt.Fprintf(&buf, "\t\t%s\n", frame)
+			}
+		}
+	}
+
+	for _, waiter := range report.Waiters {
+		if report.Semaphore != nil {
+			fmt.Fprintf(&buf, "Goroutine %d is waiting for %d permits", waiter.GoroutineID, waiter.Permits)
+		} else {
+			lockType := "read"
+			if waiter.IsWrite {
+				lockType = "write"
+			}
+			fmt.Fprintf(&buf, "Goroutine %d is waiting for a %s lock", waiter.GoroutineID, lockType)
+		}
+		if !waiter.Since.IsZero() {
+			fmt.Fprintf(&buf, " for %v", time.Since(waiter.Since).Round(time.Millisecond))
+		}
+		if frame, ok := callerFrame(waiter.Stack); ok {
+			fmt.Fprintf(&buf, " at %s:%d", frame.File, frame.Line)
+		}
+		fmt.Fprintf(&buf, ".\n")
+	}
+	return buf.WriteTo(out)
+}
+
+// String implements fmt.Stringer.
+func (report *DeadlockReport) String() string {
+	var buf bytes.Buffer
+	_, _ = report.WriteTo(&buf)
+	return buf.String()
+}
+
+func newDeadlockReport(
+	locker sync.Locker,
+	owner GoroutineID,
+	ownerStack []uintptr,
 	usedBy map[GoroutineID]*int64,
-) {
-	if monopolizedBy != 0 {
-		fmt.Fprintf(debugPanicOut, "The lock is monopolized by goroutine %dю\n", monopolizedBy)
+	usedByInfo map[GoroutineID]*rwMutexReader,
+) *DeadlockReport {
+	report := &DeadlockReport{
+		Locker: locker,
+		Owner:  owner,
 	}
+	if owner != 0 {
+		report.OwnerStack = stackFrames(ownerStack)
+	}
+	report.Readers = deadlockReaders(usedBy, usedByInfo)
+	return report
+}
 
-	if len(usedBy) > 0 {
-		fmt.Fprintf(debugPanicOut, "There are %d goroutines holding a read lock on the locker:\n", len(usedBy))
-		gCount := 0
-		for g, lockCount := range usedBy {
-			gCount++
-			fmt.Fprintf(debugPanicOut, "\t%d. %d reader-locks by goroutine %d.\n", gCount, *lockCount, g)
+// deadlockReaders returns the goroutines holding a read lock of a RWMutex
+// (sorted by their IDs).
+func deadlockReaders(
+	usedBy map[GoroutineID]*int64,
+	usedByInfo map[GoroutineID]*rwMutexReader,
+) []DeadlockReader {
+	var readers []DeadlockReader
+	for g, lockCount := range usedBy {
+		if *lockCount == 0 {
+			continue
+		}
+		reader := DeadlockReader{
+			GoroutineID: g,
+			Count:       *lockCount,
+		}
+		if info := usedByInfo[g]; info != nil {
+			reader.Stack = stackFrames(info.stack)
 		}
+		readers = append(readers, reader)
 	}
+	sort.Slice(readers, func(i, j int) bool {
+		return readers[i].GoroutineID < readers[j].GoroutineID
+	})
+	return readers
+}
 
+func debugPanic(
+	report *DeadlockReport,
+	onDeadlock func(*DeadlockReport),
+) {
 	b := make([]byte, 1024*1024)
 	n := runtime.Stack(b, true)
-	b = b[:n]
-	panic(fmt.Sprintf("The InfiniteContext is done...\nSTACKS:\n%s\n", b))
+	report.rawStacks = b[:n]
+	report.Goroutines = parseGoroutineStacks(report.rawStacks)
+
+	if onDeadlock == nil {
+		onDeadlock = OnDeadlock
+	}
+	if onDeadlock != nil {
+		onDeadlock(report)
+		return
+	}
+
+	_, _ = report.WriteTo(debugPanicOut)
+	panic(fmt.Sprintf("The InfiniteContext is done...\nSTACKS:\n%s\n", report.rawStacks))
 }
@@ -0,0 +1,123 @@
+// Package debughttp provides an http.Handler which shows the state of
+// the named gorex mutexes (see gorex.Mutex.Name and gorex.RegisteredLockers).
+//
+// It could be mounted next to net/http/pprof:
+//
+//	http.Handle("/debug/gorex", debughttp.Handler())
+package debughttp
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xaionaro-go/gorex"
+)
+
+// Handler returns an http.Handler which lists every registered mutex
+// with its owner, recursion depth, readers and waiters (and how long
+// they wait).
+//
+// The list is rendered as HTML, or as JSON if the request has query
+// parameter "format=json" (or it accepts only "application/json").
+func Handler() http.Handler {
+	return http.HandlerFunc(serveHTTP)
+}
+
+// Locker is the state of a mutex as it is rendered by Handler.
+type Locker struct {
+	*gorex.LockerInfo
+
+	// Waiters is the list of goroutines which wait for the lock.
+	Waiters []Waiter `json:",omitempty"`
+}
+
+// Waiter is a goroutine which waits for a lock.
+type Waiter struct {
+	gorex.DeadlockWaiter
+
+	// Waiting is how long the goroutine waits.
+	Waiting time.Duration
+}
+
+// Lockers returns the state of the registered mutexes (see
+// gorex.RegisteredLockers).
+func Lockers() []Locker {
+	now := time.Now()
+	infos := gorex.RegisteredLockers()
+	result := make([]Locker, 0, len(infos))
+	for _, info := range infos {
+		locker := Locker{
+			LockerInfo: info,
+		}
+		for _, w := range info.Waiters {
+			locker.Waiters = append(locker.Waiters, Waiter{
+				DeadlockWaiter: w,
+				Waiting:        now.Sub(w.Since),
+			})
+		}
+		result = append(result, locker)
+	}
+	return result
+}
+
+func serveHTTP(w http.ResponseWriter, r *http.Request) {
+	lockers := Lockers()
+	if isJSONRequested(r) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(lockers)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pageTemplate.Execute(w, lockers); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func isJSONRequested(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "json"
+	}
+	return strings.TrimSpace(r.Header.Get("Accept")) == "application/json"
+}
+
+var pageTemplate = template.Must(template.New("page").Funcs(template.FuncMap{
+	"lockType": func(isWrite bool) string {
+		if isWrite {
+			return "write"
+		}
+		return "read"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>gorex mutexes</title>
+<style>
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+pre { margin: 0; }
+</style>
+</head>
+<body>
+<p>{{len .}} registered mutexes (<a href="?format=json">json</a>)</p>
+<table>
+<tr><th>Name</th><th>Type</th><th>Owner</th><th>Depth</th><th>Readers</th><th>Waiters</th></tr>
+{{range .}}<tr>
+<td>{{.Name}}</td>
+<td>{{.Type}}</td>
+<td>{{if .Owner}}<details><summary>goroutine {{.Owner}}</summary><pre>{{range .OwnerStack}}{{.}}
+{{end}}</pre></details>{{end}}</td>
+<td>{{if .Owner}}{{.Depth}}{{end}}</td>
+<td>{{range .Readers}}<details><summary>goroutine {{.GoroutineID}} (x{{.Count}})</summary><pre>{{range .Stack}}{{.}}
+{{end}}</pre></details>{{end}}</td>
+<td>{{range .Waiters}}goroutine {{.GoroutineID}} waits for a {{lockType .IsWrite}} lock for {{.Waiting}}<br>{{end}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
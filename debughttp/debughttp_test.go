@@ -0,0 +1,75 @@
+//go:build go1.24
+// +build go1.24
+
+package debughttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xaionaro-go/gorex"
+)
+
+func TestHandler(t *testing.T) {
+	m := &gorex.RWMutex{Name: "TestHandler"}
+	m.Lock()
+	me := gorex.GetGoroutineID()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.RLockDo(func() {})
+	}()
+	defer wg.Wait()
+	defer m.Unlock()
+	for {
+		lockers := Lockers()
+		if len(lockers) == 1 && len(lockers[0].Waiters) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	get := func(url string) string {
+		rec := httptest.NewRecorder()
+		Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	t.Run("HTML", func(t *testing.T) {
+		body := get("/debug/gorex")
+		assert.True(t, strings.Contains(body, "<td>TestHandler</td>"), body)
+		assert.True(t, strings.Contains(body, "waits for a read lock for"), body)
+	})
+	t.Run("JSON", func(t *testing.T) {
+		var lockers []struct {
+			Name    string
+			Type    string
+			Owner   gorex.GoroutineID
+			Depth   int
+			Waiters []struct {
+				IsWrite bool
+				Waiting time.Duration
+			}
+		}
+		assert.NoError(t, json.Unmarshal([]byte(get("/debug/gorex?format=json")), &lockers))
+		if !assert.Len(t, lockers, 1) {
+			return
+		}
+		assert.Equal(t, "TestHandler", lockers[0].Name)
+		assert.Equal(t, "RWMutex", lockers[0].Type)
+		assert.Equal(t, me, lockers[0].Owner)
+		assert.Equal(t, 1, lockers[0].Depth)
+		if assert.Len(t, lockers[0].Waiters, 1) {
+			assert.False(t, lockers[0].Waiters[0].IsWrite)
+			assert.True(t, lockers[0].Waiters[0].Waiting > 0)
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package gorex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrLockTimeout is returned by the *DoErr and *DoValue functions if
+// the lock was not acquired (the context finished or the timeout expired
+// before it was possible to lock).
+//
+// The returned error also wraps the error of the context, so
+// both errors.Is(err, ErrLockTimeout) and, for example,
+// errors.Is(err, context.Canceled) could be used.
+var ErrLockTimeout = errors.New("unable to lock")
+
+// lockError is the error returned if the lock was not acquired (see
+// ErrLockTimeout).
+type lockError struct {
+	ctxErr error
+}
+
+func newLockError(ctx context.Context) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return ErrLockTimeout
+	}
+	return lockError{ctxErr: ctxErr}
+}
+
+func (err lockError) Error() string {
+	return ErrLockTimeout.Error() + ": " + err.ctxErr.Error()
+}
+
+func (err lockError) Is(target error) bool {
+	return target == ErrLockTimeout
+}
+
+func (err lockError) Unwrap() error {
+	return err.ctxErr
+}
+
+// CtxLocker is a sync.Locker which could be locked with a context,
+// for example *Mutex or *RWMutex.
+type CtxLocker interface {
+	sync.Locker
+	LockCtx(ctx context.Context) bool
+	UnlockCtx(ctx context.Context)
+}
+
+var (
+	_ CtxLocker = (*Mutex)(nil)
+	_ CtxLocker = (*RWMutex)(nil)
+)
+
+// LockDoErr is analog of LockDo, but returns the error returned by fn.
+func (m *Mutex) LockDoErr(fn func() error) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return fn()
+}
+
+// LockCtxDoErr is analog of LockCtxDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping ctx.Err()) if was unable to lock.
+func (m *Mutex) LockCtxDoErr(ctx context.Context, fn func() error) error {
+	if !m.LockCtx(ctx) {
+		return newLockError(ctx)
+	}
+	defer m.UnlockCtx(ctx)
+
+	return fn()
+}
+
+// LockTimeoutDoErr is analog of LockTimeoutDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping context.DeadlineExceeded) if was unable to lock.
+func (m *Mutex) LockTimeoutDoErr(timeout time.Duration, fn func() error) error {
+	if !m.LockTimeout(timeout) {
+		return lockError{ctxErr: context.DeadlineExceeded}
+	}
+	defer m.Unlock()
+
+	return fn()
+}
+
+// LockDoErr is analog of LockDo, but returns the error returned by fn.
+func (m *RWMutex) LockDoErr(fn func() error) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return fn()
+}
+
+// LockCtxDoErr is analog of LockCtxDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping ctx.Err()) if was unable to lock.
+func (m *RWMutex) LockCtxDoErr(ctx context.Context, fn func() error) error {
+	if !m.LockCtx(ctx) {
+		return newLockError(ctx)
+	}
+	defer m.UnlockCtx(ctx)
+
+	return fn()
+}
+
+// LockTimeoutDoErr is analog of LockTimeoutDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping context.DeadlineExceeded) if was unable to lock.
+func (m *RWMutex) LockTimeoutDoErr(timeout time.Duration, fn func() error) error {
+	if !m.LockTimeout(timeout) {
+		return lockError{ctxErr: context.DeadlineExceeded}
+	}
+	defer m.Unlock()
+
+	return fn()
+}
+
+// RLockDoErr is analog of RLockDo, but returns the error returned by fn.
+func (m *RWMutex) RLockDoErr(fn func() error) error {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fn()
+}
+
+// RLockCtxDoErr is analog of RLockCtxDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping ctx.Err()) if was unable to lock.
+func (m *RWMutex) RLockCtxDoErr(ctx context.Context, fn func() error) error {
+	if !m.RLockCtx(ctx) {
+		return newLockError(ctx)
+	}
+	defer m.RUnlockCtx(ctx)
+
+	return fn()
+}
+
+// RLockTimeoutDoErr is analog of RLockTimeoutDo, but returns the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping context.DeadlineExceeded) if was unable to lock.
+func (m *RWMutex) RLockTimeoutDoErr(timeout time.Duration, fn func() error) error {
+	if !m.RLockTimeout(timeout) {
+		return lockError{ctxErr: context.DeadlineExceeded}
+	}
+	defer m.RUnlock()
+
+	return fn()
+}
+
+// LockDoValue calls fn while the locker is locked and returns the value
+// returned by fn. The locker could be a *Mutex, a *RWMutex or any other
+// sync.Locker.
+//
+// See also (*Mutex).LockDo.
+func LockDoValue[T any](locker sync.Locker, fn func() T) T {
+	locker.Lock()
+	defer locker.Unlock()
+
+	return fn()
+}
+
+// RLockDoValue is analog of LockDoValue, but the mutex is locked for reading.
+//
+// See also (*RWMutex).RLockDo.
+func RLockDoValue[T any](m *RWMutex, fn func() T) T {
+	m.RLock()
+	defer m.RUnlock()
+
+	return fn()
+}
+
+// LockCtxDoValue is analog of LockDoValue, but allows to continue the try to
+// lock only until context is done (see (*Mutex).LockCtx), and returns
+// the value and the error returned by fn.
+//
+// Returns ErrLockTimeout (wrapping ctx.Err()) if was unable to lock.
+func LockCtxDoValue[T any](ctx context.Context, locker CtxLocker, fn func() (T, error)) (T, error) {
+	if !locker.LockCtx(ctx) {
+		var zeroValue T
+		return zeroValue, newLockError(ctx)
+	}
+	defer locker.UnlockCtx(ctx)
+
+	return fn()
+}
+
+// RLockCtxDoValue is analog of LockCtxDoValue, but the mutex is locked for reading.
+//
+// Returns ErrLockTimeout (wrapping ctx.Err()) if was unable to lock.
+func RLockCtxDoValue[T any](ctx context.Context, m *RWMutex, fn func() (T, error)) (T, error) {
+	if !m.RLockCtx(ctx) {
+		var zeroValue T
+		return zeroValue, newLockError(ctx)
+	}
+	defer m.RUnlockCtx(ctx)
+
+	return fn()
+}
@@ -0,0 +1,182 @@
+package gorex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo(t *testing.T) {
+	errSomething := errors.New("something")
+	// lockedByAnotherGoroutine calls fn while "m" is locked by another goroutine.
+	lockedByAnotherGoroutine := func(m sync.Locker, fn func()) {
+		locked := make(chan struct{})
+		unlock := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.Lock()
+			close(locked)
+			<-unlock
+			m.Unlock()
+		}()
+		<-locked
+		fn()
+		close(unlock)
+		<-done
+	}
+	canceledCtx := func() context.Context {
+		ctx, cancelFn := context.WithCancel(context.Background())
+		cancelFn()
+		return ctx
+	}
+
+	t.Run("DoErr", func(t *testing.T) {
+		m := &Mutex{}
+		assert.Equal(t, errSomething, m.LockDoErr(func() error {
+			return m.LockCtxDoErr(context.Background(), func() error {
+				return errSomething
+			})
+		}))
+		assert.NoError(t, m.LockTimeoutDoErr(time.Millisecond, func() error { return nil }))
+
+		lockedByAnotherGoroutine(m, func() {
+			err := m.LockCtxDoErr(canceledCtx(), func() error { return nil })
+			assert.True(t, errors.Is(err, ErrLockTimeout), err)
+			assert.True(t, errors.Is(err, context.Canceled), err)
+
+			err = m.LockTimeoutDoErr(time.Millisecond, func() error { return nil })
+			assert.True(t, errors.Is(err, ErrLockTimeout), err)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		})
+
+		rw := &RWMutex{}
+		assert.Equal(t, errSomething, rw.RLockDoErr(func() error {
+			return rw.LockDoErr(func() error {
+				return rw.RLockCtxDoErr(context.Background(), func() error {
+					return errSomething
+				})
+			})
+		}))
+		lockedByAnotherGoroutine(rw, func() {
+			for _, err := range []error{
+				rw.LockCtxDoErr(canceledCtx(), func() error { return nil }),
+				rw.LockTimeoutDoErr(time.Millisecond, func() error { return nil }),
+				rw.RLockCtxDoErr(canceledCtx(), func() error { return nil }),
+				rw.RLockTimeoutDoErr(time.Millisecond, func() error { return nil }),
+			} {
+				assert.True(t, errors.Is(err, ErrLockTimeout), err)
+			}
+		})
+	})
+	t.Run("DoValue", func(t *testing.T) {
+		m := &Mutex{}
+		assert.Equal(t, 1, LockDoValue(m, func() int {
+			return LockDoValue(m, func() int { return 1 })
+		}))
+		v, err := LockCtxDoValue(context.Background(), m, func() (string, error) {
+			return "a", nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "a", v)
+
+		rw := &RWMutex{}
+		assert.Equal(t, 2, RLockDoValue(rw, func() int {
+			return LockDoValue(rw, func() int { return 2 })
+		}))
+		lockedByAnotherGoroutine(rw, func() {
+			_, err := RLockCtxDoValue(canceledCtx(), rw, func() (int, error) {
+				t.Error("should not be called")
+				return 0, nil
+			})
+			assert.True(t, errors.Is(err, ErrLockTimeout), err)
+			_, err = LockCtxDoValue(canceledCtx(), rw, func() (int, error) {
+				t.Error("should not be called")
+				return 0, nil
+			})
+			assert.True(t, errors.Is(err, context.Canceled), err)
+		})
+	})
+	t.Run("panic", func(t *testing.T) {
+		// recovered calls fn and returns the recovered panic.
+		recovered := func(fn func()) (result interface{}) {
+			defer func() {
+				result = recover()
+			}()
+			fn()
+			return
+		}
+
+		t.Run("Mutex", func(t *testing.T) {
+			m := &Mutex{}
+			m.LockDo(func() {
+				assert.Equal(t, 1, recovered(func() {
+					m.LockDo(func() {
+						_ = m.LockDoErr(func() error {
+							panic(1)
+						})
+					})
+				}))
+				// the outer level is still held
+				assert.Equal(t, 1, m.monopolizedDepth)
+			})
+			assert.Equal(t, GoroutineID(0), m.monopolizedBy)
+			assert.Equal(t, 0, m.monopolizedDepth)
+			lockedByAnotherGoroutine(m, func() {})
+		})
+		t.Run("RWMutex", func(t *testing.T) {
+			for _, fair := range []bool{false, true} {
+				m := &RWMutex{Fair: fair}
+				assert.Equal(t, 2, recovered(func() {
+					m.RLockDo(func() {
+						m.LockDo(func() {
+							m.RLockDo(func() {
+								_ = LockDoValue(m, func() int {
+									panic(2)
+								})
+							})
+						})
+					})
+				}))
+				assert.Equal(t, GoroutineID(0), m.lockedBy)
+				assert.Equal(t, int64(0), m.rlockCount)
+				assert.Equal(t, int64(0), m.readersCountOf(GetGoroutineID()))
+				lockedByAnotherGoroutine(m, func() {})
+			}
+		})
+		t.Run("Try_Ctx_Timeout", func(t *testing.T) {
+			ctx := context.Background()
+			m := &Mutex{}
+			rw := &RWMutex{}
+			variants := map[string]func(fn func()) bool{
+				"Mutex.LockTryDo":            m.LockTryDo,
+				"Mutex.LockCtxDo":            func(fn func()) bool { return m.LockCtxDo(ctx, fn) },
+				"Mutex.LockTimeoutDo":        func(fn func()) bool { return m.LockTimeoutDo(time.Second, fn) },
+				"RWMutex.LockTryDo":          rw.LockTryDo,
+				"RWMutex.LockCtxDo":          func(fn func()) bool { return rw.LockCtxDo(ctx, fn) },
+				"RWMutex.LockTimeoutDo":      func(fn func()) bool { return rw.LockTimeoutDo(time.Second, fn) },
+				"RWMutex.RLockTryDo":         rw.RLockTryDo,
+				"RWMutex.RLockCtxDo":         func(fn func()) bool { return rw.RLockCtxDo(ctx, fn) },
+				"RWMutex.RLockTimeoutDo":     func(fn func()) bool { return rw.RLockTimeoutDo(time.Second, fn) },
+				"RWMutex.UpgradeableRLockDo": func(fn func()) bool { rw.UpgradeableRLockDo(fn); return true },
+			}
+			for name, do := range variants {
+				assert.Equal(t, name, recovered(func() {
+					do(func() {
+						panic(name)
+					})
+				}), name)
+				assert.Equal(t, GoroutineID(0), m.monopolizedBy, name)
+				assert.Equal(t, GoroutineID(0), rw.lockedBy, name)
+				assert.Equal(t, int64(0), rw.rlockCount, name)
+				assert.Equal(t, GoroutineID(0), rw.upgradeableBy, name)
+			}
+			lockedByAnotherGoroutine(m, func() {})
+			lockedByAnotherGoroutine(rw, func() {})
+		})
+	})
+}
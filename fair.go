@@ -0,0 +1,33 @@
+package gorex
+
+// grantWaiters grants the lock to the waiters from the head of the queue while
+// it is possible: either to one writer, or to all the consecutive readers (see
+// Fair). It should be called with locked internalLocker.
+func (m *RWMutex) grantWaiters() {
+	for {
+		w := m.waiters.head()
+		if w == nil || m.lockCount != 0 {
+			return
+		}
+		switch w.kind {
+		case lockWaiterKindWrite:
+			if m.rlockCount-m.readersCountOf(w.me) != 0 {
+				return
+			}
+			m.waiters.pop()
+			m.lockCount++
+			m.lockedBy = w.me
+			w.grant()
+			return
+		case lockWaiterKindUpgradeable:
+			if m.upgradeableBy != 0 && m.upgradeableBy != w.me {
+				return
+			}
+			m.upgradeableBy = w.me
+			m.upgradeableCount++
+		}
+		m.waiters.pop()
+		w.isFirstRead = m.incMyReadersCount(w.me)
+		w.grant()
+	}
+}
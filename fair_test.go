@@ -0,0 +1,234 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFair(t *testing.T) {
+	// waitForQueueLen waits until the queue of the mutex has "count" waiters.
+	waitForQueueLen := func(locker interface{}, count int) {
+		for {
+			var l int
+			switch locker := locker.(type) {
+			case *Mutex:
+				locker.internalLocker.Lock()
+				l = len(locker.waiters.waiters)
+				locker.internalLocker.Unlock()
+			case *RWMutex:
+				locker.internalLocker.Lock()
+				l = len(locker.waiters.waiters)
+				locker.internalLocker.Unlock()
+			}
+			if l == count {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("Mutex", func(t *testing.T) {
+		t.Run("FIFO", func(t *testing.T) {
+			locker := &Mutex{Fair: true}
+			locker.Lock()
+
+			var wg sync.WaitGroup
+			var order []int
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					locker.LockDo(func() {
+						order = append(order, i)
+					})
+				}(i)
+				waitForQueueLen(locker, i+1)
+			}
+			locker.Unlock()
+			wg.Wait()
+			assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order)
+		})
+		t.Run("boundedWaiting", func(t *testing.T) {
+			locker := &Mutex{Fair: true}
+			const goroutines = 8
+
+			var acquisitions int64
+			ctx, cancelFn := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for ctx.Err() == nil {
+						locker.LockDo(func() {
+							atomic.AddInt64(&acquisitions, 1)
+						})
+					}
+				}()
+			}
+
+			for i := 0; i < 100; i++ {
+				before := atomic.LoadInt64(&acquisitions)
+				locker.LockDo(func() {
+					// every other goroutine could overtake this one at most
+					// once (plus the ones which were between the reading of
+					// the counter and the enqueueing)
+					assert.LessOrEqual(t, atomic.LoadInt64(&acquisitions)-before, int64(2*goroutines))
+				})
+			}
+			cancelFn()
+			wg.Wait()
+		})
+		t.Run("LockCtx", func(t *testing.T) {
+			locker := &Mutex{Fair: true}
+			locker.Lock()
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			ctx, cancelFn := context.WithCancel(context.Background())
+			go func() {
+				defer wg.Done()
+				assert.False(t, locker.LockCtx(ctx))
+			}()
+			waitForQueueLen(locker, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				locker.LockDo(func() {})
+			}()
+			waitForQueueLen(locker, 2)
+			cancelFn()
+			waitForQueueLen(locker, 1)
+			locker.Unlock()
+			wg.Wait()
+
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		t.Run("readersAfterQueuedWriter", func(t *testing.T) {
+			locker := &RWMutex{Fair: true}
+			locker.RLock()
+
+			var wg sync.WaitGroup
+			var order []string
+			var orderLocker sync.Mutex
+			appendOrder := func(s string) {
+				orderLocker.Lock()
+				defer orderLocker.Unlock()
+				order = append(order, s)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				locker.LockDo(func() {
+					appendOrder("writer")
+				})
+			}()
+			waitForQueueLen(locker, 1)
+
+			// a new reader does not overtake the queued writer
+			reader := func() {
+				defer wg.Done()
+				assert.False(t, locker.RLockTry())
+				locker.RLockDo(func() {
+					appendOrder("reader")
+				})
+			}
+			wg.Add(2)
+			go reader()
+			waitForQueueLen(locker, 2)
+			go reader()
+			waitForQueueLen(locker, 3)
+
+			// but the reader which already holds the lock does
+			assert.True(t, locker.RLockTry())
+			locker.RUnlock()
+
+			locker.RUnlock()
+			wg.Wait()
+			assert.Equal(t, []string{"writer", "reader", "reader"}, order)
+		})
+		t.Run("writerIsNotStarved", func(t *testing.T) {
+			locker := &RWMutex{Fair: true}
+			const goroutines = 8
+
+			ctx, cancelFn := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for ctx.Err() == nil {
+						// the read locks of the goroutines overlap, so
+						// without the queue the writer could wait forever
+						locker.RLockDo(func() {
+							time.Sleep(time.Millisecond)
+						})
+					}
+				}()
+			}
+
+			for i := 0; i < 10; i++ {
+				lockCtx, lockCancelFn := context.WithTimeout(context.Background(), 10*time.Second)
+				assert.True(t, locker.LockCtxDo(lockCtx, func() {}))
+				lockCancelFn()
+			}
+			cancelFn()
+			wg.Wait()
+		})
+		t.Run("Upgrade", func(t *testing.T) {
+			locker := &RWMutex{Fair: true}
+			locker.UpgradeableRLock()
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				locker.LockDo(func() {})
+			}()
+			waitForQueueLen(locker, 1)
+
+			// the upgrade is not queued behind the writer, which waits
+			// for the upgradeable read lock
+			locker.Upgrade()
+			locker.Downgrade()
+			locker.UpgradeableRUnlock()
+			wg.Wait()
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("RLockCtx", func(t *testing.T) {
+			locker := &RWMutex{Fair: true}
+			locker.RLock()
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			ctx, cancelFn := context.WithCancel(context.Background())
+			go func() {
+				defer wg.Done()
+				assert.False(t, locker.LockCtx(ctx))
+			}()
+			waitForQueueLen(locker, 1)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				locker.RLockDo(func() {})
+			}()
+			waitForQueueLen(locker, 2)
+
+			// the writer leaves the queue, so the reader behind it is granted
+			cancelFn()
+			wg.Wait()
+			locker.RUnlock()
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+	})
+}
@@ -1,10 +1,14 @@
 module github.com/xaionaro-go/gorex
 
-go 1.13
+go 1.18
 
 require (
-	github.com/huandu/go-tls v0.0.0-20200109070953-6f75fb441850
-	github.com/phuslu/goid v1.0.1
 	github.com/stretchr/testify v1.5.1
 	github.com/xaionaro-go/spinlock v0.0.0-20190309154744-55278e21e817
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
@@ -1,11 +1,172 @@
 package gorex
 
 import (
-	"github.com/phuslu/goid"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
+// GoroutineID is the ID of a goroutine (the same as shown in
+// call stack traces).
 type GoroutineID = uint64
 
+const (
+	// goroutineIDMaxOffset is the maximal offset of the goroutine ID within
+	// the runtime structure of a goroutine, which is checked while looking
+	// for the ID (see findGoroutineIDOffset). It is much less than the size
+	// of the structure, so the reads stay within it.
+	goroutineIDMaxOffset = 256
+
+	// goroutineIDCalibrationGoroutines is the amount of goroutines used to
+	// find the offset of the goroutine ID.
+	goroutineIDCalibrationGoroutines = 4
+
+	// goroutineIDOffsetUnknown is the value of goroutineIDOffset until
+	// the offset is looked up.
+	goroutineIDOffsetUnknown = -2
+)
+
+var (
+	// goroutineIDOffset is the offset of the goroutine ID within the runtime
+	// structure of a goroutine (see getGoroutineIDOffset).
+	goroutineIDOffset     int32 = goroutineIDOffsetUnknown
+	goroutineIDOffsetOnce sync.Once
+)
+
+// getGoroutineIDOffset returns the offset of the goroutine ID within
+// the runtime structure of a goroutine, or -1 if it is not found.
+//
+// The offset is looked up on the first call (instead of the package
+// initialization), so the programs not using the locks do not pay for it.
+func getGoroutineIDOffset() int {
+	offset := atomic.LoadInt32(&goroutineIDOffset)
+	if offset == goroutineIDOffsetUnknown {
+		offset = initGoroutineIDOffset()
+	}
+	return int(offset)
+}
+
+func initGoroutineIDOffset() int32 {
+	goroutineIDOffsetOnce.Do(func() {
+		atomic.StoreInt32(&goroutineIDOffset, int32(findGoroutineIDOffset()))
+	})
+	return atomic.LoadInt32(&goroutineIDOffset)
+}
+
+// GetGoroutineID returns the ID of the current goroutine.
+//
+// It reads the ID from the runtime structure of the goroutine directly,
+// if the position of the ID within the structure is recognized, otherwise it
+// falls back to parsing the call stack trace (see IsGoroutineIDFast).
 func GetGoroutineID() GoroutineID {
-	return GoroutineID(goid.Goid())
+	offset := getGoroutineIDOffset()
+	if offset < 0 {
+		return getGoroutineIDSlow()
+	}
+	return getGoroutineIDFast(offset)
+}
+
+// IsGoroutineIDFast returns true if GetGoroutineID reads the ID
+// from the runtime structure of the goroutine directly.
+//
+// Otherwise (if this architecture is not supported or the layout of
+// the runtime structures is not recognized) it parses the call stack trace
+// of the goroutine, which is about hundred times slower (and so are
+// all the locks of this package). The fallback is correct, just slow, so
+// it is not reported anywhere else; check this function (for example, in
+// a test) to make sure the fast path is used.
+func IsGoroutineIDFast() bool {
+	return getGoroutineIDOffset() >= 0
+}
+
+func getGoroutineIDFast(offset int) GoroutineID {
+	return *(*GoroutineID)(unsafe.Add(getg(), offset))
+}
+
+func getGoroutineIDSlow() (id GoroutineID) {
+	const prefix = len("goroutine ")
+	var buf [32]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	for idx := prefix; idx < len(b); idx++ {
+		digit := b[idx] - '0'
+		if digit > 9 {
+			break
+		}
+		id = id*10 + GoroutineID(digit)
+	}
+	return
+}
+
+// goroutineIDCandidates returns the offsets within the runtime structure
+// of the current goroutine which contain its ID.
+func goroutineIDCandidates() map[int]struct{} {
+	g := getg()
+	if g == nil {
+		return nil
+	}
+	id := getGoroutineIDSlow()
+	result := map[int]struct{}{}
+	for offset := 0; offset+8 <= goroutineIDMaxOffset; offset += 8 {
+		if *(*GoroutineID)(unsafe.Add(g, offset)) == id {
+			result[offset] = struct{}{}
+		}
+	}
+	return result
+}
+
+// findGoroutineIDOffset finds the offset of the goroutine ID within
+// the runtime structure of a goroutine. The layout of the structure
+// changes between versions of Go, so instead of hardcoding the offset it
+// is looked up: the offset should contain the ID of every goroutine.
+//
+// Returns -1 if the offset is not found.
+func findGoroutineIDOffset() int {
+	candidates := goroutineIDCandidates()
+
+	var wg sync.WaitGroup
+	var locker sync.Mutex
+	for i := 0; i < goroutineIDCalibrationGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found := goroutineIDCandidates()
+			locker.Lock()
+			defer locker.Unlock()
+			for offset := range candidates {
+				if _, ok := found[offset]; !ok {
+					delete(candidates, offset)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := -1
+	for offset := range candidates {
+		if result < 0 || offset < result {
+			result = offset
+		}
+	}
+	if result >= 0 && !isGoroutineIDOffsetValid(result) {
+		return -1
+	}
+	return result
+}
+
+// isGoroutineIDOffsetValid checks the found offset against the IDs parsed
+// from the call stack traces of the current goroutine and of a new one
+// (which was not used to find the offset).
+func isGoroutineIDOffsetValid(offset int) bool {
+	me := getGoroutineIDFast(offset)
+	if me == 0 || me != getGoroutineIDSlow() {
+		return false
+	}
+
+	isValid := make(chan bool)
+	go func() {
+		id := getGoroutineIDFast(offset)
+		isValid <- id != me && id == getGoroutineIDSlow()
+	}()
+	return <-isValid
 }
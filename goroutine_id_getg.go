@@ -0,0 +1,12 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package gorex
+
+import (
+	"unsafe"
+)
+
+// getg returns the pointer to the runtime structure of the current
+// goroutine (it is implemented in assembly).
+func getg() unsafe.Pointer
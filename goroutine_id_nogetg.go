@@ -0,0 +1,14 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package gorex
+
+import (
+	"unsafe"
+)
+
+// getg is not supported on this architecture, so the goroutine ID is
+// always parsed from the call stack trace (see IsGoroutineIDFast).
+func getg() unsafe.Pointer {
+	return nil
+}
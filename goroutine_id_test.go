@@ -0,0 +1,54 @@
+package gorex
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGoroutineID(t *testing.T) {
+	me := GetGoroutineID()
+	assert.NotZero(t, me)
+	assert.Equal(t, getGoroutineIDSlow(), me)
+
+	ch := make(chan GoroutineID)
+	go func() {
+		ch <- GetGoroutineID()
+	}()
+	assert.NotEqual(t, me, <-ch)
+
+	t.Run("fast", func(t *testing.T) {
+		switch runtime.GOARCH {
+		case "amd64", "arm64":
+			// the slow fallback makes every lock ~100 times slower, so
+			// it should not be taken silently on the supported architectures
+			assert.True(t, IsGoroutineIDFast(), "the offset of goroutine ID is not found, GetGoroutineID is slow")
+		default:
+			t.Skipf("getg is not implemented on %s", runtime.GOARCH)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				runtime.Gosched()
+				assert.Equal(t, getGoroutineIDSlow(), GetGoroutineID())
+			}()
+		}
+		wg.Wait()
+
+		offset := getGoroutineIDOffset()
+		assert.True(t, isGoroutineIDOffsetValid(offset))
+		// the stack bounds are in the beginning of the structure
+		assert.False(t, isGoroutineIDOffsetValid(0))
+	})
+}
+
+func BenchmarkGetGoroutineID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		GetGoroutineID()
+	}
+}
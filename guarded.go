@@ -0,0 +1,126 @@
+package gorex
+
+import (
+	"context"
+)
+
+// Guarded is a value which could be accessed only while the internal Mutex
+// is locked. It allows to do not forget to lock the mutex and to do not
+// accidentally access the value outside of the critical section.
+//
+// The same as Mutex it could be locked multiple times by the same
+// goroutine (for example, Do could be called inside Do).
+//
+// The zero-value is a valid guarded zero-value of T.
+type Guarded[T any] struct {
+	mutex Mutex
+	value T
+}
+
+// NewGuarded returns a new Guarded with the initial value.
+func NewGuarded[T any](value T) *Guarded[T] {
+	return &Guarded[T]{value: value}
+}
+
+// Do calls fn with a pointer to the value while the mutex is locked.
+//
+// The pointer should not be used after fn returned.
+func (g *Guarded[T]) Do(fn func(*T)) {
+	g.mutex.LockDo(func() {
+		fn(&g.value)
+	})
+}
+
+// DoCtx is analog of Do, but allows to continue the try to lock only until
+// context is done.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *Guarded[T]) DoCtx(ctx context.Context, fn func(*T)) bool {
+	return g.mutex.LockCtxDo(ctx, func() {
+		fn(&g.value)
+	})
+}
+
+// TryDo is analog of Do, but it does not block if it cannot lock right away.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *Guarded[T]) TryDo(fn func(*T)) bool {
+	return g.mutex.LockTryDo(func() {
+		fn(&g.value)
+	})
+}
+
+// RDo calls fn with a copy of the value while the mutex is locked.
+func (g *Guarded[T]) RDo(fn func(T)) {
+	g.mutex.LockDo(func() {
+		fn(g.value)
+	})
+}
+
+// RWGuarded is analog of Guarded, but uses RWMutex, so multiple readers
+// (see RDo) could access the value concurrently.
+//
+// The zero-value is a valid guarded zero-value of T.
+type RWGuarded[T any] struct {
+	mutex RWMutex
+	value T
+}
+
+// NewRWGuarded returns a new RWGuarded with the initial value.
+func NewRWGuarded[T any](value T) *RWGuarded[T] {
+	return &RWGuarded[T]{value: value}
+}
+
+// Do calls fn with a pointer to the value while the mutex is write-locked.
+//
+// The pointer should not be used after fn returned.
+func (g *RWGuarded[T]) Do(fn func(*T)) {
+	g.mutex.LockDo(func() {
+		fn(&g.value)
+	})
+}
+
+// DoCtx is analog of Do, but allows to continue the try to lock only until
+// context is done.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *RWGuarded[T]) DoCtx(ctx context.Context, fn func(*T)) bool {
+	return g.mutex.LockCtxDo(ctx, func() {
+		fn(&g.value)
+	})
+}
+
+// TryDo is analog of Do, but it does not block if it cannot lock right away.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *RWGuarded[T]) TryDo(fn func(*T)) bool {
+	return g.mutex.LockTryDo(func() {
+		fn(&g.value)
+	})
+}
+
+// RDo calls fn with a copy of the value while the mutex is read-locked.
+func (g *RWGuarded[T]) RDo(fn func(T)) {
+	g.mutex.RLockDo(func() {
+		fn(g.value)
+	})
+}
+
+// RDoCtx is analog of RDo, but allows to continue the try to lock only until
+// context is done.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *RWGuarded[T]) RDoCtx(ctx context.Context, fn func(T)) bool {
+	return g.mutex.RLockCtxDo(ctx, func() {
+		fn(g.value)
+	})
+}
+
+// RTryDo is analog of RDo, but it does not block if it cannot lock right away.
+//
+// Returns `false` if was unable to lock (and fn was not called).
+func (g *RWGuarded[T]) RTryDo(fn func(T)) bool {
+	return g.mutex.RLockTryDo(func() {
+		fn(g.value)
+	})
+}
@@ -0,0 +1,123 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuarded(t *testing.T) {
+	t.Run("Do", func(t *testing.T) {
+		g := NewGuarded(map[string]int{})
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					g.Do(func(m *map[string]int) {
+						(*m)["counter"]++
+						g.RDo(func(m map[string]int) {
+							assert.NotZero(t, m["counter"])
+						})
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		g.RDo(func(m map[string]int) {
+			assert.Equal(t, 1000, m["counter"])
+		})
+	})
+	t.Run("TryDo", func(t *testing.T) {
+		var g Guarded[int]
+		var wg0, wg1 sync.WaitGroup
+		wg0.Add(1)
+		wg1.Add(1)
+		go g.Do(func(v *int) {
+			wg1.Done()
+			wg0.Wait()
+		})
+		wg1.Wait()
+		assert.False(t, g.TryDo(func(v *int) {
+			*v = 1
+		}))
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancelFn()
+		assert.False(t, g.DoCtx(ctx, func(v *int) {
+			*v = 1
+		}))
+		wg0.Done()
+
+		assert.True(t, g.DoCtx(context.Background(), func(v *int) {
+			*v = 2
+		}))
+		g.RDo(func(v int) {
+			assert.Equal(t, 2, v)
+		})
+	})
+}
+
+func TestRWGuarded(t *testing.T) {
+	t.Run("Do", func(t *testing.T) {
+		g := NewRWGuarded(0)
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					g.Do(func(v *int) {
+						*v++
+					})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					g.RDo(func(v int) {
+						g.RDo(func(v2 int) {
+							assert.Equal(t, v, v2)
+						})
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		g.RDo(func(v int) {
+			assert.Equal(t, 1000, v)
+		})
+	})
+	t.Run("TryDo", func(t *testing.T) {
+		var g RWGuarded[int]
+		var wg0, wg1 sync.WaitGroup
+		wg0.Add(1)
+		wg1.Add(1)
+		go g.RDo(func(v int) {
+			wg1.Done()
+			wg0.Wait()
+		})
+		wg1.Wait()
+		assert.True(t, g.RTryDo(func(v int) {}))
+		assert.True(t, g.RDoCtx(context.Background(), func(v int) {}))
+		assert.False(t, g.TryDo(func(v *int) {
+			*v = 1
+		}))
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		defer cancelFn()
+		assert.False(t, g.DoCtx(ctx, func(v *int) {
+			*v = 1
+		}))
+		wg0.Done()
+
+		assert.True(t, g.DoCtx(context.Background(), func(v *int) {
+			*v = 2
+		}))
+		g.RDo(func(v int) {
+			assert.Equal(t, 2, v)
+		})
+	})
+}
@@ -0,0 +1,233 @@
+package gorex
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// virtualOwnerIDBit marks the IDs which are not IDs of real goroutines
+// (for example, the owner of a lock which was handed off, but not adopted,
+// yet). IDs of real goroutines never reach this bit.
+const virtualOwnerIDBit = GoroutineID(1) << 63
+
+var lastVirtualOwnerID uint64
+
+// newVirtualOwnerID returns an unique ID which could be used as an owner
+// of a lock, but which is not an ID of any goroutine.
+func newVirtualOwnerID() GoroutineID {
+	return virtualOwnerIDBit | GoroutineID(atomic.AddUint64(&lastVirtualOwnerID, 1))
+}
+
+// handOffLocker is a mutex which supports HandOff.
+type handOffLocker interface {
+	adopt(owner GoroutineID, isWrite bool)
+}
+
+// Token is a lock handed off by a goroutine (see (*Mutex).HandOff,
+// (*RWMutex).HandOff and (*RWMutex).RHandOff), which waits to be adopted
+// by another goroutine (see Adopt).
+//
+// While the lock is not adopted, it is held by nobody: other goroutines
+// (including the goroutine which handed it off) will wait for it.
+type Token struct {
+	locker  handOffLocker
+	owner   GoroutineID
+	isWrite bool
+}
+
+// Adopt makes the calling goroutine the owner of the handed off lock,
+// so it could use it the same way as if it locked the mutex itself
+// (for example, to Unlock it or to lock it again reentrantly).
+//
+// A token could be adopted only once.
+func (t Token) Adopt() {
+	if t.locker == nil {
+		panic("An attempt to adopt an empty token.")
+	}
+	t.locker.adopt(t.owner, t.isWrite)
+}
+
+// HandOff transfers the lock held by the calling goroutine (with all
+// the levels of reentrant locking) to another goroutine, which should call
+// Adopt of the returned Token. After that the other goroutine is the owner of
+// the lock and could Unlock it without panicking with
+// "I'm not the one, who locked this mutex".
+//
+// For example:
+//
+//	m.Lock()
+//	.. do some stuff ..
+//	token := m.HandOff()
+//	go func() {
+//	    token.Adopt()
+//	    defer m.Unlock()
+//	    .. finish the stuff ..
+//	}()
+func (m *Mutex) HandOff() Token {
+	me := GetGoroutineID()
+	owner := newVirtualOwnerID()
+
+	m.internalLocker.Lock()
+	switch {
+	case m.monopolizedBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off a non-locked mutex.")
+	case me != m.monopolizedBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.monopolizedBy))
+	}
+	m.monopolizedBy = owner
+	depth := m.monopolizedDepth
+	goroutineClosedLock(m, me, true)
+	m.internalLocker.Unlock()
+	lockOrderHandedOff(m, me, owner, depth, true)
+
+	return Token{
+		locker:  m,
+		owner:   owner,
+		isWrite: true,
+	}
+}
+
+func (m *Mutex) adopt(owner GoroutineID, _ bool) {
+	me := GetGoroutineID()
+
+	m.internalLocker.Lock()
+	if m.monopolizedBy != owner {
+		m.internalLocker.Unlock()
+		panic("An attempt to adopt a token, which is already adopted.")
+	}
+	m.monopolizedBy = me
+	depth := m.monopolizedDepth
+	m.holdWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, true, m.monopolizedStack)
+	goroutineOpenedLock(m, me, true)
+	m.internalLocker.Unlock()
+	lockOrderHandedOff(m, owner, me, depth, true)
+}
+
+// HandOff is analog of (*Mutex).HandOff, but for the write lock.
+//
+// A lock acquired by Upgrade cannot be handed off, and neither can a lock
+// of a goroutine which also holds read locks (the new owner would not own
+// them, call RUnlock first or see RHandOff).
+func (m *RWMutex) HandOff() Token {
+	me := GetGoroutineID()
+	owner := newVirtualOwnerID()
+
+	m.internalLocker.Lock()
+	switch {
+	case m.lockedBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off a non-locked mutex.")
+	case me != m.lockedBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.lockedBy))
+	case m.upgradeDepth > 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off an upgraded mutex, call Downgrade() first.")
+	case m.readersCountOf(me) != 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off a mutex, which is also RLock()-ed, call RUnlock() first.")
+	}
+	m.lockedBy = owner
+	depth := m.lockCount
+	goroutineClosedLock(m, me, true)
+	m.internalLocker.Unlock()
+	lockOrderHandedOff(m, me, owner, depth, true)
+
+	return Token{
+		locker:  m,
+		owner:   owner,
+		isWrite: true,
+	}
+}
+
+// RHandOff is analog of HandOff, but for the read locks (all the read locks
+// held by the calling goroutine are handed off).
+//
+// An upgradeable read lock (see UpgradeableRLock) cannot be handed off, and
+// neither can the read locks of a goroutine which also holds the write lock
+// (the new owner would read while the write lock is held).
+func (m *RWMutex) RHandOff() Token {
+	m.lazyInit()
+	me := GetGoroutineID()
+	owner := newVirtualOwnerID()
+
+	m.internalLocker.Lock()
+	v := m.usedBy[me]
+	switch {
+	case v == nil || *v == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off a non-RLock()-ed mutex.")
+	case m.upgradeableBy == me:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off an UpgradeableRLock()-ed mutex.")
+	case m.lockedBy == me:
+		m.internalLocker.Unlock()
+		panic("An attempt to hand off read locks of a mutex, which is also Lock()-ed, call Unlock() first.")
+	}
+	depth := int(*v)
+	m.usedBy[owner] = v
+	delete(m.usedBy, me)
+	if info := m.usedByInfo[me]; info != nil {
+		m.usedByInfo[owner] = info
+		delete(m.usedByInfo, me)
+	}
+	goroutineClosedLock(m, me, false)
+	m.internalLocker.Unlock()
+	lockOrderHandedOff(m, me, owner, depth, false)
+
+	return Token{
+		locker:  m,
+		owner:   owner,
+		isWrite: false,
+	}
+}
+
+func (m *RWMutex) adopt(owner GoroutineID, isWrite bool) {
+	me := GetGoroutineID()
+
+	m.internalLocker.Lock()
+	var depth int
+	if isWrite {
+		if m.lockedBy != owner {
+			m.internalLocker.Unlock()
+			panic("An attempt to adopt a token, which is already adopted.")
+		}
+		m.lockedBy = me
+		depth = m.lockCount
+		m.lockedByWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, true, m.lockedByStack)
+		goroutineOpenedLock(m, me, true)
+	} else {
+		v := m.usedBy[owner]
+		if v == nil {
+			m.internalLocker.Unlock()
+			panic("An attempt to adopt a token, which is already adopted.")
+		}
+		depth = int(*v)
+		info := m.usedByInfo[owner]
+		delete(m.usedBy, owner)
+		delete(m.usedByInfo, owner)
+
+		mine := m.usedBy[me]
+		if mine == nil || *mine == 0 {
+			if mine != nil {
+				m.int64Pool.put(mine)
+			}
+			m.usedBy[me] = v
+			if info != nil {
+				info.holdWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, false, info.stack)
+				m.usedByInfo[me] = info
+			}
+			goroutineOpenedLock(m, me, false)
+		} else {
+			*mine += *v
+			m.int64Pool.put(v)
+			if info != nil {
+				info.holdWatchdog.stop()
+			}
+		}
+	}
+	m.internalLocker.Unlock()
+	lockOrderHandedOff(m, owner, me, depth, isWrite)
+}
@@ -0,0 +1,138 @@
+package gorex
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandOff(t *testing.T) {
+	inGoroutine := func(fn func()) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fn()
+		}()
+		<-done
+	}
+
+	t.Run("Mutex", func(t *testing.T) {
+		locker := &Mutex{}
+		assert.Panics(t, func() { locker.HandOff() })
+
+		locker.Lock()
+		locker.Lock()
+		token := locker.HandOff()
+		assert.Panics(t, locker.Unlock)
+		assert.False(t, locker.LockTry())
+
+		inGoroutine(func() {
+			token.Adopt()
+			assert.Panics(t, token.Adopt)
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+			locker.Unlock()
+			locker.Unlock()
+			assert.Panics(t, locker.Unlock)
+		})
+
+		assert.True(t, locker.LockTry())
+		locker.Unlock()
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		t.Run("write", func(t *testing.T) {
+			locker := &RWMutex{}
+			assert.Panics(t, func() { locker.HandOff() })
+
+			locker.Lock()
+			token := locker.HandOff()
+			assert.Panics(t, locker.Unlock)
+			assert.False(t, locker.RLockTry())
+
+			inGoroutine(func() {
+				token.Adopt()
+				assert.Panics(t, token.Adopt)
+				locker.Downgrade()
+				locker.RUnlock()
+			})
+
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("read", func(t *testing.T) {
+			locker := &RWMutex{}
+			assert.Panics(t, func() { locker.RHandOff() })
+
+			locker.RLock()
+			locker.RLock()
+			token := locker.RHandOff()
+			assert.Panics(t, locker.RUnlock)
+
+			inGoroutine(func() {
+				locker.RLock()
+				token.Adopt()
+				assert.Panics(t, token.Adopt)
+				locker.RUnlock()
+				locker.RUnlock()
+				locker.RUnlock()
+				assert.Panics(t, locker.RUnlock)
+			})
+
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("upgraded", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.UpgradeableRLock()
+			assert.Panics(t, func() { locker.RHandOff() })
+			locker.Upgrade()
+			assert.Panics(t, func() { locker.HandOff() })
+			locker.Downgrade()
+			locker.UpgradeableRUnlock()
+		})
+		t.Run("read_locked", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.RLock()
+			assert.True(t, locker.LockTry())
+			assert.Panics(t, func() { locker.HandOff() })
+			locker.Unlock()
+			locker.RUnlock()
+
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("write_locked", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.Lock()
+			locker.RLock()
+			assert.Panics(t, func() { locker.RHandOff() })
+			locker.RUnlock()
+			locker.Unlock()
+
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+	})
+	t.Run("empty_token", func(t *testing.T) {
+		assert.Panics(t, Token{}.Adopt)
+	})
+	t.Run("lock_order", func(t *testing.T) {
+		SetLockOrderDetection(true)
+		defer SetLockOrderDetection(false)
+
+		locker := &Mutex{}
+		locker.Lock()
+		token := locker.HandOff()
+		inGoroutine(func() {
+			token.Adopt()
+			me := GetGoroutineID()
+			globalLockOrderGraph.locker.Lock()
+			assert.Equal(t, 1, globalLockOrderGraph.held[me][locker].depth)
+			globalLockOrderGraph.locker.Unlock()
+			locker.Unlock()
+		})
+		globalLockOrderGraph.locker.Lock()
+		defer globalLockOrderGraph.locker.Unlock()
+		assert.Nil(t, globalLockOrderGraph.held[GetGoroutineID()][locker])
+	})
+}
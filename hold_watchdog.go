@@ -0,0 +1,168 @@
+package gorex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/xaionaro-go/spinlock"
+)
+
+// DefaultHoldWarnThreshold is the default maximal duration a lock (write or read)
+// could be held by a goroutine before reporting it via OnLongHold.
+//
+// The zero-value means to do not watch for the hold durations.
+var DefaultHoldWarnThreshold time.Duration
+
+// OnLongHold is called when a lock is held longer than the threshold
+// (see DefaultHoldWarnThreshold) and the mutex does not have its own
+// OnLongHold handler. It is called once per offending acquisition,
+// from a separate goroutine, while the lock is still held.
+//
+// The zero-value means to print the report to stderr.
+var OnLongHold func(*LongHoldReport)
+
+// LongHoldReport is the debugging information about a lock which is held
+// for too long.
+type LongHoldReport struct {
+	// Locker is the mutex (*Mutex or *RWMutex).
+	Locker sync.Locker `json:"-"`
+
+	// GoroutineID is the ID of goroutine which holds the lock (if the lock
+	// was handed off, but not adopted, yet, then it is the goroutine which
+	// handed it off, see Mutex.HandOff).
+	GoroutineID GoroutineID
+
+	// IsWrite is true if the held lock is a write lock.
+	IsWrite bool
+
+	// Stack is the call stack trace where the lock was acquired.
+	Stack []StackFrame `json:",omitempty"`
+
+	// Elapsed is how long the lock is held (since it was adopted, if it was
+	// handed off).
+	Elapsed time.Duration
+}
+
+// WriteTo writes a human-readable report to "out".
+func (report *LongHoldReport) WriteTo(out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	lockType := "read"
+	if report.IsWrite {
+		lockType = "write"
+	}
+	fmt.Fprintf(&buf, "Goroutine %d holds a %s lock for %v, the lock was acquired at:\n",
+		report.GoroutineID, lockType, report.Elapsed)
+	for _, frame := range report.Stack {
+		fmt.Fprintf(&buf, "\t%s\n", frame)
+	}
+	return buf.WriteTo(out)
+}
+
+// String implements fmt.Stringer.
+func (report *LongHoldReport) String() string {
+	var buf bytes.Buffer
+	_, _ = report.WriteTo(&buf)
+	return buf.String()
+}
+
+// holdWatchdog reports a lock which is held longer than the threshold
+// (see DefaultHoldWarnThreshold). It is reused by the acquisitions of the same
+// lock (it has a single timer), so the watching does not allocate on every
+// acquisition.
+//
+// The zero-value is ready to use.
+type holdWatchdog struct {
+	internalLocker spinlock.Locker
+	timer          *time.Timer
+	locker         sync.Locker
+	onLongHold     func(*LongHoldReport)
+	me             GoroutineID
+	isWrite        bool
+	stack          []uintptr
+	acquiredAt     time.Time
+	threshold      time.Duration
+	isActive       bool
+	isReported     bool
+}
+
+// start starts watching for the acquisition of "locker" by "me" (see also stop).
+//
+// If the watchdog is already started, then it is restarted for the new
+// acquisition (for example, when the lock is adopted, see Token.Adopt).
+func (w *holdWatchdog) start(
+	locker sync.Locker,
+	threshold time.Duration,
+	onLongHold func(*LongHoldReport),
+	me GoroutineID,
+	isWrite bool,
+	stack []uintptr,
+) {
+	if threshold == 0 {
+		threshold = DefaultHoldWarnThreshold
+	}
+	if threshold <= 0 {
+		w.stop()
+		return
+	}
+
+	w.internalLocker.Lock()
+	w.locker = locker
+	w.onLongHold = onLongHold
+	w.me = me
+	w.isWrite = isWrite
+	w.stack = append(w.stack[:0], stack...)
+	w.acquiredAt = time.Now()
+	w.threshold = threshold
+	w.isActive = true
+	w.isReported = false
+	if w.timer == nil {
+		w.timer = time.AfterFunc(threshold, w.fire)
+	} else {
+		w.timer.Reset(threshold)
+	}
+	w.internalLocker.Unlock()
+}
+
+// stop stops watching (the lock is released).
+func (w *holdWatchdog) stop() {
+	w.internalLocker.Lock()
+	w.isActive = false
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.internalLocker.Unlock()
+}
+
+func (w *holdWatchdog) fire() {
+	w.internalLocker.Lock()
+	elapsed := time.Since(w.acquiredAt)
+	// the timer could fire for a previous acquisition, if it was
+	// unable to stop it in time
+	if !w.isActive || w.isReported || elapsed < w.threshold {
+		w.internalLocker.Unlock()
+		return
+	}
+	w.isReported = true
+	report := &LongHoldReport{
+		Locker:      w.locker,
+		GoroutineID: w.me,
+		IsWrite:     w.isWrite,
+		Elapsed:     elapsed,
+	}
+	stack := append([]uintptr(nil), w.stack...)
+	onLongHold := w.onLongHold
+	w.internalLocker.Unlock()
+
+	report.Stack = stackFrames(stack)
+	if onLongHold == nil {
+		onLongHold = OnLongHold
+	}
+	if onLongHold != nil {
+		onLongHold(report)
+		return
+	}
+	_, _ = report.WriteTo(debugPanicOut)
+}
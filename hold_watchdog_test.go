@@ -0,0 +1,140 @@
+package gorex
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldWatchdog(t *testing.T) {
+	newReportsCollector := func() (func(*LongHoldReport), func() []*LongHoldReport) {
+		var locker sync.Mutex
+		var reports []*LongHoldReport
+		return func(report *LongHoldReport) {
+				locker.Lock()
+				defer locker.Unlock()
+				reports = append(reports, report)
+			}, func() []*LongHoldReport {
+				locker.Lock()
+				defer locker.Unlock()
+				return reports
+			}
+	}
+
+	t.Run("positive", func(t *testing.T) {
+		t.Run("Mutex", func(t *testing.T) {
+			onLongHold, getReports := newReportsCollector()
+			locker := &Mutex{
+				HoldWarnThreshold: time.Millisecond,
+				OnLongHold:        onLongHold,
+			}
+			locker.LockDo(func() {
+				locker.LockDo(func() {
+					time.Sleep(20 * time.Millisecond)
+				})
+			})
+
+			reports := getReports()
+			if !assert.Len(t, reports, 1) {
+				return
+			}
+			assert.Equal(t, locker, reports[0].Locker)
+			assert.Equal(t, GetGoroutineID(), reports[0].GoroutineID)
+			assert.True(t, reports[0].IsWrite)
+			assert.NotEmpty(t, reports[0].Stack)
+			assert.GreaterOrEqual(t, int64(reports[0].Elapsed), int64(time.Millisecond))
+		})
+		t.Run("RWMutex", func(t *testing.T) {
+			onLongHold, getReports := newReportsCollector()
+			locker := &RWMutex{
+				HoldWarnThreshold: time.Millisecond,
+				OnLongHold:        onLongHold,
+			}
+			locker.RLockDo(func() {
+				time.Sleep(20 * time.Millisecond)
+			})
+			locker.LockDo(func() {
+				time.Sleep(20 * time.Millisecond)
+			})
+
+			reports := getReports()
+			if !assert.Len(t, reports, 2) {
+				return
+			}
+			assert.False(t, reports[0].IsWrite)
+			assert.True(t, reports[1].IsWrite)
+		})
+	})
+	t.Run("HandOff", func(t *testing.T) {
+		onLongHold, getReports := newReportsCollector()
+		mutex := &Mutex{
+			HoldWarnThreshold: 10 * time.Millisecond,
+			OnLongHold:        onLongHold,
+		}
+		rwMutex := &RWMutex{
+			HoldWarnThreshold: 10 * time.Millisecond,
+			OnLongHold:        onLongHold,
+		}
+		rMutex := &RWMutex{
+			HoldWarnThreshold: 10 * time.Millisecond,
+			OnLongHold:        onLongHold,
+		}
+		mutex.Lock()
+		rwMutex.Lock()
+		rMutex.RLock()
+		tokens := []Token{mutex.HandOff(), rwMutex.HandOff(), rMutex.RHandOff()}
+
+		adopterID := make(chan GoroutineID)
+		go func() {
+			for _, token := range tokens {
+				token.Adopt()
+			}
+			time.Sleep(30 * time.Millisecond)
+			mutex.Unlock()
+			rwMutex.Unlock()
+			rMutex.RUnlock()
+			adopterID <- GetGoroutineID()
+		}()
+		me := <-adopterID
+
+		reports := getReports()
+		if !assert.Len(t, reports, 3) {
+			return
+		}
+		for _, report := range reports {
+			assert.Equal(t, me, report.GoroutineID)
+			assert.NotEmpty(t, report.Stack)
+		}
+	})
+	t.Run("no_allocations", func(t *testing.T) {
+		var w holdWatchdog
+		locker := &Mutex{}
+		stack := []uintptr{1, 2, 3}
+		w.start(locker, time.Hour, nil, 1, true, stack)
+		w.stop()
+		assert.Zero(t, testing.AllocsPerRun(100, func() {
+			w.start(locker, time.Hour, nil, 1, true, stack)
+			w.stop()
+		}))
+	})
+	t.Run("negative", func(t *testing.T) {
+		onLongHold, getReports := newReportsCollector()
+		mutex := &Mutex{
+			HoldWarnThreshold: 10 * time.Millisecond,
+			OnLongHold:        onLongHold,
+		}
+		rwMutex := &RWMutex{
+			HoldWarnThreshold: 10 * time.Millisecond,
+			OnLongHold:        onLongHold,
+		}
+		for i := 0; i < 10; i++ {
+			mutex.LockDo(func() {})
+			rwMutex.LockDo(func() {})
+			rwMutex.RLockDo(func() {})
+		}
+		time.Sleep(20 * time.Millisecond)
+		assert.Len(t, getReports(), 0)
+	})
+}
@@ -0,0 +1,351 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xaionaro-go/spinlock"
+)
+
+// KeyedMutex is a set of Mutex-es identified by keys (for example, to lock
+// per user ID or per file path), so it works the same way as
+// map[K]*Mutex guarded by another mutex, but a Mutex exists only while
+// it is used (locked or waited for) and it is freed (reused for other keys)
+// after that.
+//
+// The same as Mutex it could be locked multiple times by the same goroutine
+// (per key).
+//
+// The zero-value is a valid KeyedMutex.
+type KeyedMutex[K comparable] struct {
+	lockers keyedLockers[K, Mutex]
+}
+
+// Lock is analog of (*Mutex).Lock, but for the mutex of the key.
+func (m *KeyedMutex[K]) Lock(key K) {
+	m.lockers.acquire(key).Lock()
+}
+
+// LockTry is analog of (*Mutex).LockTry, but for the mutex of the key.
+func (m *KeyedMutex[K]) LockTry(key K) bool {
+	if m.lockers.acquire(key).LockTry() {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// LockCtx is analog of (*Mutex).LockCtx, but for the mutex of the key.
+func (m *KeyedMutex[K]) LockCtx(ctx context.Context, key K) bool {
+	if m.lockers.acquire(key).LockCtx(ctx) {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// Unlock is analog of (*Mutex).Unlock, but for the mutex of the key.
+func (m *KeyedMutex[K]) Unlock(key K) {
+	m.lockers.get(key).Unlock()
+	m.lockers.release(key)
+}
+
+// UnlockCtx is analog of (*Mutex).UnlockCtx, but for the mutex of the key.
+func (m *KeyedMutex[K]) UnlockCtx(ctx context.Context, key K) {
+	m.lockers.get(key).UnlockCtx(ctx)
+	m.lockers.release(key)
+}
+
+// LockDo is a wrapper around Lock and Unlock.
+//
+// See also (*Mutex).LockDo.
+func (m *KeyedMutex[K]) LockDo(key K, fn func()) {
+	m.Lock(key)
+	defer m.Unlock(key)
+
+	fn()
+}
+
+// LockTryDo is a wrapper around LockTry and Unlock.
+//
+// See also LockDo and LockTry.
+func (m *KeyedMutex[K]) LockTryDo(key K, fn func()) (success bool) {
+	if !m.LockTry(key) {
+		return false
+	}
+	defer m.Unlock(key)
+
+	success = true
+	fn()
+	return
+}
+
+// LockCtxDo is a wrapper around LockCtx and UnlockCtx.
+//
+// See also LockDo and LockCtx.
+func (m *KeyedMutex[K]) LockCtxDo(ctx context.Context, key K, fn func()) (success bool) {
+	if !m.LockCtx(ctx, key) {
+		return false
+	}
+	defer m.UnlockCtx(ctx, key)
+
+	success = true
+	fn()
+	return
+}
+
+// Len returns the amount of keys which are currently used (locked or
+// waited for).
+func (m *KeyedMutex[K]) Len() int {
+	return m.lockers.len()
+}
+
+// KeyedRWMutex is analog of KeyedMutex, but for RWMutex-es.
+//
+// The zero-value is a valid KeyedRWMutex.
+type KeyedRWMutex[K comparable] struct {
+	lockers keyedLockers[K, RWMutex]
+}
+
+// Lock is analog of (*RWMutex).Lock, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) Lock(key K) {
+	m.lockers.acquire(key).Lock()
+}
+
+// LockTry is analog of (*RWMutex).LockTry, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) LockTry(key K) bool {
+	if m.lockers.acquire(key).LockTry() {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// LockCtx is analog of (*RWMutex).LockCtx, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) LockCtx(ctx context.Context, key K) bool {
+	if m.lockers.acquire(key).LockCtx(ctx) {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// Unlock is analog of (*RWMutex).Unlock, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) Unlock(key K) {
+	m.lockers.get(key).Unlock()
+	m.lockers.release(key)
+}
+
+// UnlockCtx is analog of (*RWMutex).UnlockCtx, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) UnlockCtx(ctx context.Context, key K) {
+	m.lockers.get(key).UnlockCtx(ctx)
+	m.lockers.release(key)
+}
+
+// LockDo is a wrapper around Lock and Unlock.
+//
+// See also (*RWMutex).LockDo.
+func (m *KeyedRWMutex[K]) LockDo(key K, fn func()) {
+	m.Lock(key)
+	defer m.Unlock(key)
+
+	fn()
+}
+
+// LockTryDo is a wrapper around LockTry and Unlock.
+//
+// See also LockDo and LockTry.
+func (m *KeyedRWMutex[K]) LockTryDo(key K, fn func()) (success bool) {
+	if !m.LockTry(key) {
+		return false
+	}
+	defer m.Unlock(key)
+
+	success = true
+	fn()
+	return
+}
+
+// LockCtxDo is a wrapper around LockCtx and UnlockCtx.
+//
+// See also LockDo and LockCtx.
+func (m *KeyedRWMutex[K]) LockCtxDo(ctx context.Context, key K, fn func()) (success bool) {
+	if !m.LockCtx(ctx, key) {
+		return false
+	}
+	defer m.UnlockCtx(ctx, key)
+
+	success = true
+	fn()
+	return
+}
+
+// RLock is analog of (*RWMutex).RLock, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) RLock(key K) {
+	m.lockers.acquire(key).RLock()
+}
+
+// RLockTry is analog of (*RWMutex).RLockTry, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) RLockTry(key K) bool {
+	if m.lockers.acquire(key).RLockTry() {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// RLockCtx is analog of (*RWMutex).RLockCtx, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) RLockCtx(ctx context.Context, key K) bool {
+	if m.lockers.acquire(key).RLockCtx(ctx) {
+		return true
+	}
+	m.lockers.release(key)
+	return false
+}
+
+// RUnlock is analog of (*RWMutex).RUnlock, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) RUnlock(key K) {
+	m.lockers.get(key).RUnlock()
+	m.lockers.release(key)
+}
+
+// RUnlockCtx is analog of (*RWMutex).RUnlockCtx, but for the mutex of the key.
+func (m *KeyedRWMutex[K]) RUnlockCtx(ctx context.Context, key K) {
+	m.lockers.get(key).RUnlockCtx(ctx)
+	m.lockers.release(key)
+}
+
+// RLockDo is a wrapper around RLock and RUnlock.
+//
+// See also (*RWMutex).RLockDo.
+func (m *KeyedRWMutex[K]) RLockDo(key K, fn func()) {
+	m.RLock(key)
+	defer m.RUnlock(key)
+
+	fn()
+}
+
+// RLockTryDo is a wrapper around RLockTry and RUnlock.
+//
+// See also RLockDo and RLockTry.
+func (m *KeyedRWMutex[K]) RLockTryDo(key K, fn func()) (success bool) {
+	if !m.RLockTry(key) {
+		return false
+	}
+	defer m.RUnlock(key)
+
+	success = true
+	fn()
+	return
+}
+
+// RLockCtxDo is a wrapper around RLockCtx and RUnlockCtx.
+//
+// See also RLockDo and RLockCtx.
+func (m *KeyedRWMutex[K]) RLockCtxDo(ctx context.Context, key K, fn func()) (success bool) {
+	if !m.RLockCtx(ctx, key) {
+		return false
+	}
+	defer m.RUnlockCtx(ctx, key)
+
+	success = true
+	fn()
+	return
+}
+
+// Len returns the amount of keys which are currently used (locked or
+// waited for).
+func (m *KeyedRWMutex[K]) Len() int {
+	return m.lockers.len()
+}
+
+// keyedLocker is a mutex of a key with the amount of its users.
+type keyedLocker[T any] struct {
+	locker T
+
+	// refCount is the amount of the acquisitions (including the waiting
+	// ones) which are not released, yet.
+	refCount int64
+}
+
+// keyedLockers is a reference-counted map of mutexes (*Mutex or *RWMutex).
+type keyedLockers[K comparable, T any] struct {
+	internalLocker spinlock.Locker
+	lockers        map[K]*keyedLocker[T]
+	pool           keyedLockerPool[T]
+}
+
+// acquire returns the mutex of the key (creating it if required) and
+// increments its reference counter. Each call should be paired with
+// a call of release.
+func (l *keyedLockers[K, T]) acquire(key K) *T {
+	l.internalLocker.Lock()
+	defer l.internalLocker.Unlock()
+
+	if l.lockers == nil {
+		l.lockers = map[K]*keyedLocker[T]{}
+	}
+	locker := l.lockers[key]
+	if locker == nil {
+		locker = l.pool.get()
+		l.lockers[key] = locker
+	}
+	locker.refCount++
+	return &locker.locker
+}
+
+// get returns the mutex of the key, which is already acquired.
+func (l *keyedLockers[K, T]) get(key K) *T {
+	l.internalLocker.Lock()
+	locker := l.lockers[key]
+	l.internalLocker.Unlock()
+	if locker == nil {
+		panic("An attempt to unlock a non-locked mutex.")
+	}
+	return &locker.locker
+}
+
+// release decrements the reference counter of the mutex of the key, and
+// frees the mutex if it is not used anymore.
+func (l *keyedLockers[K, T]) release(key K) {
+	l.internalLocker.Lock()
+	defer l.internalLocker.Unlock()
+
+	locker := l.lockers[key]
+	locker.refCount--
+	if locker.refCount != 0 {
+		return
+	}
+	delete(l.lockers, key)
+	lockOrderForget(any(&locker.locker).(sync.Locker))
+	l.pool.put(locker)
+}
+
+func (l *keyedLockers[K, T]) len() int {
+	l.internalLocker.Lock()
+	defer l.internalLocker.Unlock()
+	return len(l.lockers)
+}
+
+// keyedLockerPool is analog of int64Pool, but for keyedLocker-s.
+type keyedLockerPool[T any] []*keyedLocker[T]
+
+func (pool *keyedLockerPool[T]) put(v *keyedLocker[T]) {
+	*pool = append(*pool, v)
+}
+
+func (pool *keyedLockerPool[T]) get() *keyedLocker[T] {
+	if len(*pool) == 0 {
+		lockers := make([]keyedLocker[T], 16)
+		for i := range lockers {
+			pool.put(&lockers[i])
+		}
+	}
+
+	idx := len(*pool) - 1
+	v := (*pool)[idx]
+	(*pool)[idx] = nil
+	*pool = (*pool)[:idx]
+
+	return v
+}
@@ -0,0 +1,132 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	timeoutCtx := func() context.Context {
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		t.Cleanup(cancelFn)
+		return ctx
+	}
+
+	t.Run("reentrant", func(t *testing.T) {
+		m := &KeyedMutex[string]{}
+		m.LockDo("a", func() {
+			m.LockDo("a", func() {
+				m.LockDo("b", func() {
+					assert.Equal(t, 2, m.Len())
+				})
+				assert.Equal(t, 1, m.Len())
+			})
+		})
+		assert.Equal(t, 0, m.Len())
+	})
+	t.Run("exclusion", func(t *testing.T) {
+		m := &KeyedMutex[int]{}
+		m.Lock(1)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.False(t, m.LockTry(1))
+			assert.False(t, m.LockCtx(timeoutCtx(), 1))
+			assert.True(t, m.LockTryDo(2, func() {}))
+		}()
+		wg.Wait()
+		assert.Equal(t, 1, m.Len())
+
+		i := 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.LockDo(1, func() {
+				i = 2
+			})
+		}()
+		time.Sleep(time.Millisecond)
+		i = 1
+		m.Unlock(1)
+		wg.Wait()
+		assert.Equal(t, 2, i)
+		assert.Equal(t, 0, m.Len())
+	})
+	t.Run("cleanup", func(t *testing.T) {
+		m := &KeyedMutex[int]{}
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					m.LockDo((i+j)%10, func() {})
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.Equal(t, 0, m.Len())
+		// there were at most 10 keys at a time, so the mutexes were reused
+		assert.Len(t, m.lockers.pool, 16)
+	})
+	t.Run("negative", func(t *testing.T) {
+		m := &KeyedMutex[string]{}
+		assert.Panics(t, func() { m.Unlock("a") })
+		m.Lock("a")
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Panics(t, func() { m.Unlock("a") })
+		}()
+		wg.Wait()
+		m.Unlock("a")
+		assert.Equal(t, 0, m.Len())
+	})
+}
+
+func TestKeyedRWMutex(t *testing.T) {
+	t.Run("read", func(t *testing.T) {
+		m := &KeyedRWMutex[string]{}
+		m.RLockDo("a", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.True(t, m.RLockTryDo("a", func() {}))
+				assert.False(t, m.LockTry("a"))
+				assert.True(t, m.LockTryDo("b", func() {}))
+			}()
+			wg.Wait()
+
+			// the same goroutine could lock the key for writing
+			m.LockDo("a", func() {
+				m.RLockDo("a", func() {})
+			})
+			assert.Equal(t, 1, m.Len())
+		})
+		assert.Equal(t, 0, m.Len())
+	})
+	t.Run("write", func(t *testing.T) {
+		m := &KeyedRWMutex[string]{}
+		ctx := context.Background()
+		assert.True(t, m.LockCtxDo(ctx, "a", func() {
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.False(t, m.RLockTry("a"))
+				assert.True(t, m.RLockCtxDo(ctx, "b", func() {}))
+			}()
+			wg.Wait()
+		}))
+		assert.Equal(t, 0, m.Len())
+	})
+}
@@ -0,0 +1,248 @@
+package gorex
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+const (
+	// lockAllSpinAttempts is the amount of failed attempts of LockAll after
+	// which it starts to sleep between attempts (before that it only yields
+	// the processor).
+	lockAllSpinAttempts = 4
+
+	// lockAllMaxBackoff is the maximal duration of sleeping between
+	// attempts of LockAll.
+	lockAllMaxBackoff = time.Millisecond
+)
+
+// multiLocker is a lock which could be acquired by LockAll.
+type multiLocker interface {
+	sync.Locker
+
+	// lockAddr is the address of the mutex, it defines the order of
+	// acquiring in LockAll.
+	lockAddr() uintptr
+
+	// isWriteLock is true if the lock is exclusive.
+	isWriteLock() bool
+
+	lockBy(ctx context.Context, me GoroutineID, shouldWait bool) bool
+	unlockBy(me GoroutineID)
+}
+
+func (m *Mutex) lockAddr() uintptr {
+	return uintptr(unsafe.Pointer(m))
+}
+
+func (m *Mutex) isWriteLock() bool {
+	return true
+}
+
+func (m *Mutex) lockBy(ctx context.Context, me GoroutineID, shouldWait bool) bool {
+	return m.lock(ctx, me, shouldWait)
+}
+
+func (m *Mutex) unlockBy(me GoroutineID) {
+	m.unlock(me)
+}
+
+func (m *RWMutex) lockAddr() uintptr {
+	return uintptr(unsafe.Pointer(m))
+}
+
+func (m *RWMutex) isWriteLock() bool {
+	return true
+}
+
+func (m *RWMutex) lockBy(ctx context.Context, me GoroutineID, shouldWait bool) bool {
+	return m.lock(ctx, me, shouldWait)
+}
+
+func (m *RWMutex) unlockBy(me GoroutineID) {
+	m.unlock(me)
+}
+
+// RLocker returns a sync.Locker which locks the mutex for reading (calls
+// RLock and RUnlock). It is analog of (*sync.RWMutex).RLocker, and also it
+// could be passed to LockAll to acquire a read lock.
+func (m *RWMutex) RLocker() sync.Locker {
+	return (*rLocker)(m)
+}
+
+// rLocker is a RWMutex which is locked for reading (see RLocker).
+type rLocker RWMutex
+
+func (r *rLocker) Lock() {
+	(*RWMutex)(r).RLock()
+}
+
+func (r *rLocker) Unlock() {
+	(*RWMutex)(r).RUnlock()
+}
+
+func (r *rLocker) lockAddr() uintptr {
+	return uintptr(unsafe.Pointer(r))
+}
+
+func (r *rLocker) isWriteLock() bool {
+	return false
+}
+
+func (r *rLocker) lockBy(ctx context.Context, me GoroutineID, shouldWait bool) bool {
+	return (*RWMutex)(r).rLock(ctx, me, shouldWait)
+}
+
+func (r *rLocker) unlockBy(me GoroutineID) {
+	(*RWMutex)(r).rUnlock(me)
+}
+
+// LockAll acquires all the locks, or nothing. Each locker should be a *Mutex,
+// a *RWMutex (locked for writing) or a (*RWMutex).RLocker() (locked for
+// reading).
+//
+// The locks are acquired in a globally consistent order (by address of the
+// mutexes). Only the first lock is waited for while nothing else is held; if
+// any other lock cannot be acquired right away, then all the acquired locks
+// are released, and LockAll retries after a backoff starting with the contended
+// lock. So LockAll cannot deadlock with another LockAll or with a code which
+// locks the same mutexes in any other order.
+//
+// If the context is nil, then the InfiniteContext-s of the mutexes are used
+// (see (*Mutex).Lock). If the context carries a lock owner (see WithOwner),
+// then the locks are acquired by this owner instead of the calling goroutine.
+//
+// The locks should be released by UnlockAll (or by the Unlock/RUnlock of
+// each mutex).
+//
+// Returns `false` if was unable to lock (context finished before it was
+// possible to lock), in this case nothing is acquired.
+func LockAll(ctx context.Context, lockers ...sync.Locker) bool {
+	return lockAll(ctx, lockOwnerID(ctx), toMultiLockers(lockers))
+}
+
+// UnlockAll releases the locks acquired by LockAll.
+//
+// The context should carry the same lock owner as the context passed
+// to LockAll (see WithOwner).
+func UnlockAll(ctx context.Context, lockers ...sync.Locker) {
+	unlockAll(lockOwnerID(ctx), toMultiLockers(lockers))
+}
+
+// LockAllDo is a wrapper around LockAll and UnlockAll.
+//
+// See also LockAll.
+func LockAllDo(ctx context.Context, lockers []sync.Locker, fn func()) (success bool) {
+	me := lockOwnerID(ctx)
+	sorted := toMultiLockers(lockers)
+	if !lockAll(ctx, me, sorted) {
+		return false
+	}
+	defer unlockAll(me, sorted)
+
+	success = true
+	fn()
+	return
+}
+
+// toMultiLockers returns the lockers in the order of acquiring (see LockAll).
+func toMultiLockers(lockers []sync.Locker) []multiLocker {
+	result := make([]multiLocker, 0, len(lockers))
+	for _, l := range lockers {
+		ml, ok := l.(multiLocker)
+		if !ok {
+			panic(fmt.Sprintf("%T is not supported by LockAll, only *Mutex, *RWMutex and (*RWMutex).RLocker() are supported.", l))
+		}
+		result = append(result, ml)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.lockAddr() != b.lockAddr() {
+			return a.lockAddr() < b.lockAddr()
+		}
+		// the write lock of a mutex goes before its read lock, otherwise
+		// the write lock would wait for our own reader
+		return a.isWriteLock() && !b.isWriteLock()
+	})
+	return result
+}
+
+func lockAll(ctx context.Context, me GoroutineID, lockers []multiLocker) bool {
+	if len(lockers) == 0 {
+		return true
+	}
+
+	waitIdx := 0
+	for attempt := 0; ; attempt++ {
+		if !lockers[waitIdx].lockBy(ctx, me, true) {
+			return false
+		}
+
+		failedIdx := -1
+		for idx, l := range lockers {
+			if idx == waitIdx {
+				continue
+			}
+			if !l.lockBy(nil, me, false) {
+				failedIdx = idx
+				break
+			}
+		}
+		if failedIdx < 0 {
+			return true
+		}
+
+		// release everything (in the reverse order) and try again
+		lockers[waitIdx].unlockBy(me)
+		for idx := failedIdx - 1; idx >= 0; idx-- {
+			if idx == waitIdx {
+				continue
+			}
+			lockers[idx].unlockBy(me)
+		}
+		if !lockAllBackoff(ctx, attempt) {
+			return false
+		}
+		// next time wait for the contended lock
+		waitIdx = failedIdx
+	}
+}
+
+// lockAllBackoff waits before the next attempt of LockAll. Returns `false`
+// if the context finished before that.
+func lockAllBackoff(ctx context.Context, attempt int) bool {
+	if attempt < lockAllSpinAttempts {
+		runtime.Gosched()
+		return true
+	}
+
+	backoff := lockAllMaxBackoff
+	if shift := attempt - lockAllSpinAttempts; shift < 10 {
+		if d := time.Microsecond << shift; d < backoff {
+			backoff = d
+		}
+	}
+	if ctx == nil {
+		time.Sleep(backoff)
+		return true
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func unlockAll(me GoroutineID, lockers []multiLocker) {
+	for idx := len(lockers) - 1; idx >= 0; idx-- {
+		lockers[idx].unlockBy(me)
+	}
+}
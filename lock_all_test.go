@@ -0,0 +1,117 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockAll(t *testing.T) {
+	t.Run("reverseOrder", func(t *testing.T) {
+		a, b := &Mutex{}, &RWMutex{}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					LockAllDo(nil, []sync.Locker{a, b}, func() {})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					LockAllDo(nil, []sync.Locker{b, a}, func() {})
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					// locking manually in the reverse order
+					b.LockDo(func() {
+						a.LockDo(func() {})
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		assert.True(t, a.LockTry())
+		assert.True(t, b.LockTry())
+	})
+	t.Run("allOrNothing", func(t *testing.T) {
+		a, b, c := &Mutex{}, &Mutex{}, &RWMutex{}
+		c.RLock()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancelFn := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancelFn()
+			assert.False(t, LockAll(ctx, a, b, c))
+
+			// readers do not exclude each other
+			assert.True(t, LockAll(context.Background(), a, c.RLocker()))
+			UnlockAll(context.Background(), c.RLocker(), a)
+		}()
+		wg.Wait()
+		assert.True(t, a.LockTry())
+		assert.True(t, b.LockTry())
+		a.Unlock()
+		b.Unlock()
+		c.RUnlock()
+	})
+	t.Run("reentrant", func(t *testing.T) {
+		a, b := &Mutex{}, &RWMutex{}
+		assert.True(t, LockAllDo(context.Background(), []sync.Locker{a, b, b.RLocker()}, func() {
+			assert.True(t, LockAllDo(context.Background(), []sync.Locker{b.RLocker(), a}, func() {}))
+		}))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, a.LockTry())
+			assert.True(t, b.LockTry())
+		}()
+		wg.Wait()
+	})
+	t.Run("WithOwner", func(t *testing.T) {
+		a, b := &Mutex{}, &Mutex{}
+		ctx := WithOwner(context.Background())
+		assert.True(t, LockAll(ctx, a, b))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			UnlockAll(ctx, a, b)
+		}()
+		wg.Wait()
+		assert.True(t, a.LockTry())
+		a.Unlock()
+	})
+	t.Run("RLocker", func(t *testing.T) {
+		m := &RWMutex{}
+		var l sync.Locker = m.RLocker()
+		l.Lock()
+		assert.True(t, m.RLockTry())
+		assert.False(t, func() bool {
+			result := make(chan bool)
+			go func() {
+				result <- m.LockTry()
+			}()
+			return <-result
+		}())
+		m.RUnlock()
+		l.Unlock()
+	})
+	t.Run("negative", func(t *testing.T) {
+		assert.Panics(t, func() {
+			LockAll(context.Background(), &sync.Mutex{})
+		})
+	})
+}
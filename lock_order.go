@@ -0,0 +1,428 @@
+package gorex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// lockOrderStackDepth is the maximal depth of stack traces stored
+	// in the lock-order graph.
+	lockOrderStackDepth = 32
+)
+
+// LockOrderEdge is a fact that a goroutine acquired lock "To" while it
+// was holding lock "From".
+type LockOrderEdge struct {
+	// From is the lock which was held.
+	From sync.Locker
+
+	// To is the lock which was acquired while From was held.
+	To sync.Locker
+
+	// Goroutine is the ID of goroutine which acquired both locks.
+	Goroutine GoroutineID
+
+	// FromStack is the stack trace of the acquisition of From.
+	FromStack []uintptr
+
+	// ToStack is the stack trace of the acquisition of To.
+	ToStack []uintptr
+
+	// FromIsWrite is true if From was held with a write lock.
+	FromIsWrite bool
+
+	// ToIsWrite is true if To was acquired with a write lock.
+	ToIsWrite bool
+}
+
+// lockOrderKind returns the human-readable kind of a lock.
+func lockOrderKind(isWrite bool) string {
+	if isWrite {
+		return "write"
+	}
+	return "read"
+}
+
+// LockOrderViolation is a report about a cycle found in the lock-order graph.
+//
+// A cycle means a potential deadlock: each edge is safe on its own, but if
+// the goroutines of these edges will run concurrently they may wait for
+// each other forever.
+type LockOrderViolation struct {
+	// Cycle is the list of edges forming the cycle, the "To" of
+	// each edge is the "From" of the next one (and the "To" of the last one
+	// is the "From" of the first one).
+	Cycle []LockOrderEdge
+}
+
+// WriteTo writes a human-readable report to "out".
+func (v *LockOrderViolation) WriteTo(out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "potential deadlock: a cycle of %d edges in the lock order:\n", len(v.Cycle))
+	for idx, edge := range v.Cycle {
+		fmt.Fprintf(&buf, "%d. goroutine %d acquired a %s lock of %p while holding a %s lock of %p.\n",
+			idx+1, edge.Goroutine, lockOrderKind(edge.ToIsWrite), edge.To, lockOrderKind(edge.FromIsWrite), edge.From)
+		fmt.Fprintf(&buf, "%p was acquired at:\n", edge.From)
+		printStack(&buf, edge.FromStack)
+		fmt.Fprintf(&buf, "%p was acquired at:\n", edge.To)
+		printStack(&buf, edge.ToStack)
+	}
+	return buf.WriteTo(out)
+}
+
+// String implements fmt.Stringer.
+func (v *LockOrderViolation) String() string {
+	var buf bytes.Buffer
+	_, _ = v.WriteTo(&buf)
+	return buf.String()
+}
+
+// OnLockOrderViolation is called if the lock-order detection is enabled
+// (see SetLockOrderDetection) and a cycle is found in the lock-order graph.
+//
+// The zero-value means to print the report to stderr and panic.
+var OnLockOrderViolation func(*LockOrderViolation)
+
+var lockOrderDetectionEnabled uint32
+
+// SetLockOrderDetection enables or disables the lock-order detection.
+//
+// If enabled, every acquisition of a Mutex or RWMutex while holding
+// another Mutex or RWMutex records an edge "held lock -> acquired lock"
+// into the global lock-order graph. And if the graph gets a cycle, then
+// it is reported via OnLockOrderViolation even if the deadlock never
+// actually happened.
+//
+// A cycle is reported only if each of its locks could block the goroutine
+// acquiring it: read locks of a RWMutex do not block each other (unless
+// the RWMutex is Fair: then a waiting writer blocks new readers). An upgradeable
+// read lock (see UpgradeableRLock) is considered as a write lock here, because
+// two of them exclude each other.
+//
+// The graph keeps references to every lock ever participated in it, so
+// this mode is supposed to be used only in tests and while debugging.
+func SetLockOrderDetection(enable bool) {
+	var v uint32
+	if enable {
+		v = 1
+	}
+	atomic.StoreUint32(&lockOrderDetectionEnabled, v)
+}
+
+func isLockOrderDetectionEnabled() bool {
+	return atomic.LoadUint32(&lockOrderDetectionEnabled) != 0
+}
+
+type lockOrderHeld struct {
+	depth int
+
+	// writeDepth is the amount of the levels of depth, which are write locks.
+	writeDepth int
+
+	stack []uintptr
+}
+
+func (h *lockOrderHeld) isWrite() bool {
+	return h.writeDepth > 0
+}
+
+// lockOrderEdgeKey identifies an edge among the edges with the same "From".
+type lockOrderEdgeKey struct {
+	to          sync.Locker
+	fromIsWrite bool
+	toIsWrite   bool
+}
+
+type lockOrderGraph struct {
+	locker sync.Mutex
+	edges  map[sync.Locker]map[lockOrderEdgeKey]*LockOrderEdge
+	held   map[GoroutineID]map[sync.Locker]*lockOrderHeld
+}
+
+var globalLockOrderGraph = lockOrderGraph{
+	edges: map[sync.Locker]map[lockOrderEdgeKey]*LockOrderEdge{},
+	held:  map[GoroutineID]map[sync.Locker]*lockOrderHeld{},
+}
+
+// lockOrderBeforeLock is called before a (potentially) blocking acquisition
+// of lock "l" by goroutine "me". It records the lock-order edges and
+// reports a violation if a cycle was found.
+func lockOrderBeforeLock(l sync.Locker, me GoroutineID, isWrite bool) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	violations := globalLockOrderGraph.addEdges(l, me, isWrite)
+	for _, violation := range violations {
+		reportLockOrderViolation(violation)
+	}
+}
+
+// lockOrderLocked is called after every successful acquisition
+// (including reentrant ones) of lock "l" by goroutine "me".
+func lockOrderLocked(l sync.Locker, me GoroutineID, isWrite bool) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	g := &globalLockOrderGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	myHeld := g.held[me]
+	if myHeld == nil {
+		myHeld = map[sync.Locker]*lockOrderHeld{}
+		g.held[me] = myHeld
+	}
+	h := myHeld[l]
+	if h == nil {
+		h = &lockOrderHeld{
+			stack: callers(1, lockOrderStackDepth),
+		}
+		myHeld[l] = h
+	}
+	h.depth++
+	if isWrite {
+		h.writeDepth++
+	}
+}
+
+// lockOrderUnlocked is called after every release (including reentrant ones)
+// of lock "l" by goroutine "me".
+func lockOrderUnlocked(l sync.Locker, me GoroutineID, isWrite bool) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	g := &globalLockOrderGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	myHeld := g.held[me]
+	h := myHeld[l]
+	if h == nil {
+		// the lock was acquired before the detection was enabled
+		return
+	}
+	h.depth--
+	if isWrite && h.writeDepth > 0 {
+		h.writeDepth--
+	}
+	if h.depth > 0 {
+		return
+	}
+	delete(myHeld, l)
+	if len(myHeld) == 0 {
+		delete(g.held, me)
+	}
+}
+
+// lockOrderDowngraded is called when a level of the write lock "l" held by
+// goroutine "me" becomes a read lock (see Downgrade).
+func lockOrderDowngraded(l sync.Locker, me GoroutineID) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	g := &globalLockOrderGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	if h := g.held[me][l]; h != nil && h.writeDepth > 0 {
+		h.writeDepth--
+	}
+}
+
+// lockOrderHandedOff is called when "depth" levels of lock "l" are
+// transferred from goroutine "from" to goroutine "to" (see HandOff).
+func lockOrderHandedOff(l sync.Locker, from, to GoroutineID, depth int, isWrite bool) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	g := &globalLockOrderGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	fromHeld := g.held[from]
+	h := fromHeld[l]
+	if h == nil {
+		// the lock was acquired before the detection was enabled
+		return
+	}
+	if depth > h.depth {
+		depth = h.depth
+	}
+	writeDepth := 0
+	if isWrite {
+		writeDepth = depth
+		if writeDepth > h.writeDepth {
+			writeDepth = h.writeDepth
+		}
+	}
+	h.depth -= depth
+	h.writeDepth -= writeDepth
+	if h.depth == 0 {
+		delete(fromHeld, l)
+		if len(fromHeld) == 0 {
+			delete(g.held, from)
+		}
+	}
+
+	toHeld := g.held[to]
+	if toHeld == nil {
+		toHeld = map[sync.Locker]*lockOrderHeld{}
+		g.held[to] = toHeld
+	}
+	if toHeld[l] == nil {
+		toHeld[l] = &lockOrderHeld{
+			stack: h.stack,
+		}
+	}
+	toHeld[l].depth += depth
+	toHeld[l].writeDepth += writeDepth
+}
+
+// lockOrderForget is called when lock "l" is not going to be used anymore
+// (and its memory could be reused for another lock, see KeyedMutex). It removes
+// the edges of the lock from the lock-order graph.
+func lockOrderForget(l sync.Locker) {
+	if !isLockOrderDetectionEnabled() {
+		return
+	}
+
+	g := &globalLockOrderGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	delete(g.edges, l)
+	for from, edges := range g.edges {
+		for key := range edges {
+			if key.to == l {
+				delete(edges, key)
+			}
+		}
+		if len(edges) == 0 {
+			delete(g.edges, from)
+		}
+	}
+}
+
+func (g *lockOrderGraph) addEdges(l sync.Locker, me GoroutineID, isWrite bool) []*LockOrderViolation {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	myHeld := g.held[me]
+	if len(myHeld) == 0 {
+		return nil
+	}
+	if _, isReentrant := myHeld[l]; isReentrant {
+		return nil
+	}
+
+	var (
+		stack      []uintptr
+		violations []*LockOrderViolation
+	)
+	for from, h := range myHeld {
+		key := lockOrderEdgeKey{
+			to:          l,
+			fromIsWrite: h.isWrite(),
+			toIsWrite:   isWrite,
+		}
+		if _, alreadyKnown := g.edges[from][key]; alreadyKnown {
+			continue
+		}
+		if stack == nil {
+			stack = callers(2, lockOrderStackDepth)
+		}
+		edge := &LockOrderEdge{
+			From:        from,
+			To:          l,
+			Goroutine:   me,
+			FromStack:   h.stack,
+			ToStack:     stack,
+			FromIsWrite: key.fromIsWrite,
+			ToIsWrite:   key.toIsWrite,
+		}
+		if g.edges[from] == nil {
+			g.edges[from] = map[lockOrderEdgeKey]*LockOrderEdge{}
+		}
+		g.edges[from][key] = edge
+
+		path := g.findPath(l, isWrite, from, edge.FromIsWrite, map[lockOrderVisit]struct{}{})
+		if path == nil {
+			continue
+		}
+		cycle := make([]LockOrderEdge, 0, len(path)+1)
+		cycle = append(cycle, *edge)
+		for _, pathEdge := range path {
+			cycle = append(cycle, *pathEdge)
+		}
+		violations = append(violations, &LockOrderViolation{Cycle: cycle})
+	}
+	return violations
+}
+
+// lockOrderVisit is a lock visited by findPath, acquired with
+// the specified kind.
+type lockOrderVisit struct {
+	locker  sync.Locker
+	isWrite bool
+}
+
+// lockOrderBlocks returns true if an acquisition of lock "l" could be blocked
+// by the goroutine holding it (see SetLockOrderDetection).
+func lockOrderBlocks(l sync.Locker, isAcquiredWrite, isHeldWrite bool) bool {
+	if isAcquiredWrite || isHeldWrite {
+		return true
+	}
+	rw, ok := l.(*RWMutex)
+	return ok && rw.Fair
+}
+
+// findPath returns the edges of a path from "from" (acquired with a write
+// lock if "fromIsWrite") to "to" (held with a write lock if "toIsWrite")
+// or nil if there is no such path. Only the paths where each lock could block
+// the goroutine acquiring it are considered (see lockOrderBlocks).
+func (g *lockOrderGraph) findPath(
+	from sync.Locker,
+	fromIsWrite bool,
+	to sync.Locker,
+	toIsWrite bool,
+	visited map[lockOrderVisit]struct{},
+) []*LockOrderEdge {
+	visited[lockOrderVisit{locker: from, isWrite: fromIsWrite}] = struct{}{}
+	for key, edge := range g.edges[from] {
+		if !lockOrderBlocks(from, fromIsWrite, key.fromIsWrite) {
+			continue
+		}
+		if key.to == to {
+			if !lockOrderBlocks(to, key.toIsWrite, toIsWrite) {
+				continue
+			}
+			return []*LockOrderEdge{edge}
+		}
+		if _, isVisited := visited[lockOrderVisit{locker: key.to, isWrite: key.toIsWrite}]; isVisited {
+			continue
+		}
+		if path := g.findPath(key.to, key.toIsWrite, to, toIsWrite, visited); path != nil {
+			return append([]*LockOrderEdge{edge}, path...)
+		}
+	}
+	return nil
+}
+
+func reportLockOrderViolation(violation *LockOrderViolation) {
+	if OnLockOrderViolation != nil {
+		OnLockOrderViolation(violation)
+		return
+	}
+
+	_, _ = violation.WriteTo(debugPanicOut)
+	panic("potential deadlock: lock order violation")
+}
@@ -0,0 +1,158 @@
+package gorex
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withLockOrderDetection(t *testing.T, fn func(violations *[]*LockOrderViolation)) {
+	var violations []*LockOrderViolation
+	oldHandler := OnLockOrderViolation
+	OnLockOrderViolation = func(v *LockOrderViolation) {
+		violations = append(violations, v)
+	}
+	SetLockOrderDetection(true)
+	defer func() {
+		SetLockOrderDetection(false)
+		OnLockOrderViolation = oldHandler
+	}()
+
+	fn(&violations)
+}
+
+func TestLockOrderDetection(t *testing.T) {
+	t.Run("positive", func(t *testing.T) {
+		t.Run("Mutex", func(t *testing.T) {
+			withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+				a, b := &Mutex{}, &Mutex{}
+				a.LockDo(func() {
+					b.LockDo(func() {})
+				})
+				assert.Len(t, *violations, 0)
+				b.LockDo(func() {
+					a.LockDo(func() {})
+				})
+				if !assert.Len(t, *violations, 1) {
+					return
+				}
+				cycle := (*violations)[0].Cycle
+				assert.Len(t, cycle, 2)
+				for _, edge := range cycle {
+					assert.NotEmpty(t, edge.FromStack)
+					assert.NotEmpty(t, edge.ToStack)
+					assert.Equal(t, GetGoroutineID(), edge.Goroutine)
+				}
+				assert.Equal(t, cycle[0].To, cycle[1].From)
+				assert.Equal(t, cycle[1].To, cycle[0].From)
+			})
+		})
+		t.Run("RWMutex", func(t *testing.T) {
+			withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+				a, b, c := &RWMutex{}, &Mutex{}, &RWMutex{}
+				a.RLockDo(func() {
+					b.LockDo(func() {})
+				})
+				b.LockDo(func() {
+					c.LockDo(func() {})
+				})
+				assert.Len(t, *violations, 0)
+				c.RLockDo(func() {
+					a.LockDo(func() {})
+				})
+				if !assert.Len(t, *violations, 1) {
+					return
+				}
+				assert.Len(t, (*violations)[0].Cycle, 3)
+			})
+		})
+		t.Run("Fair_read", func(t *testing.T) {
+			withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+				// a waiting writer blocks new readers of a fair mutex
+				a, b := &RWMutex{Fair: true}, &RWMutex{Fair: true}
+				a.RLockDo(func() {
+					b.RLockDo(func() {})
+				})
+				b.RLockDo(func() {
+					a.RLockDo(func() {})
+				})
+				if !assert.Len(t, *violations, 1) {
+					return
+				}
+				for _, edge := range (*violations)[0].Cycle {
+					assert.False(t, edge.FromIsWrite)
+					assert.False(t, edge.ToIsWrite)
+				}
+			})
+		})
+		t.Run("defaultHandler", func(t *testing.T) {
+			SetLockOrderDetection(true)
+			defer SetLockOrderDetection(false)
+			oldOut := debugPanicOut
+			debugPanicOut = io.Discard
+			defer func() { debugPanicOut = oldOut }()
+
+			a, b := &Mutex{}, &Mutex{}
+			a.LockDo(func() {
+				b.LockDo(func() {})
+			})
+			var result interface{}
+			b.LockDo(func() {
+				defer func() {
+					result = recover()
+				}()
+				a.LockDo(func() {})
+			})
+			assert.NotNil(t, result)
+		})
+	})
+	t.Run("negative", func(t *testing.T) {
+		withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+			a, b := &Mutex{}, &RWMutex{}
+			for i := 0; i < 2; i++ {
+				a.LockDo(func() {
+					b.RLockDo(func() {
+						a.LockDo(func() {})
+						b.LockDo(func() {})
+					})
+				})
+			}
+			assert.Len(t, *violations, 0)
+		})
+		t.Run("read", func(t *testing.T) {
+			withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+				a, b := &RWMutex{}, &RWMutex{}
+				a.RLockDo(func() {
+					b.RLockDo(func() {})
+				})
+				b.RLockDo(func() {
+					a.RLockDo(func() {})
+				})
+				// b is read-locked by both goroutines (the write lock
+				// is downgraded), so only a could block
+				b.Lock()
+				b.Downgrade()
+				a.LockDo(func() {})
+				b.RUnlock()
+				assert.Len(t, *violations, 0)
+			})
+		})
+		t.Run("KeyedMutex", func(t *testing.T) {
+			withLockOrderDetection(t, func(violations *[]*LockOrderViolation) {
+				m := &KeyedMutex[int]{}
+				m.LockDo(1, func() {
+					m.LockDo(2, func() {})
+				})
+				// the mutexes of keys 1 and 2 are reused for keys 3, 4 and 5
+				// (in the reversed order), it is not a cycle
+				m.Lock(3)
+				m.LockDo(4, func() {
+					m.Unlock(3)
+					m.LockDo(5, func() {})
+				})
+				assert.Len(t, *violations, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,197 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lockTimeout is a context which is done after a timeout. It is used by
+// LockTimeout and RLockTimeout instead of context.WithTimeout: it is pooled
+// (so it does not allocate anything) and its timer is started only if
+// the goroutine really has to wait.
+//
+// The context could be waited only by lockWaiter.wait (its Done() is nil).
+type lockTimeout struct {
+	deadline  time.Time
+	timer     *time.Timer
+	isStarted bool
+	isExpired bool
+}
+
+var _ context.Context = (*lockTimeout)(nil)
+
+var lockTimeoutPool = sync.Pool{
+	New: func() any {
+		return &lockTimeout{}
+	},
+}
+
+// newLockTimeout returns a lockTimeout from the pool. It should be returned
+// back by release.
+func newLockTimeout(timeout time.Duration) *lockTimeout {
+	t := lockTimeoutPool.Get().(*lockTimeout)
+	t.deadline = time.Now().Add(timeout)
+	return t
+}
+
+// release stops the timer and returns the lockTimeout to the pool.
+func (t *lockTimeout) release() {
+	if t.isStarted && !t.timer.Stop() && !t.isExpired {
+		// the timer fired, but the value was not received, drain it so
+		// that it will not fire the next use
+		select {
+		case <-t.timer.C:
+		default:
+		}
+	}
+	t.isStarted = false
+	t.isExpired = false
+	lockTimeoutPool.Put(t)
+}
+
+// start starts the timer (if it is not started, yet) and returns its channel.
+//
+// Returns nil if the timeout is already expired.
+func (t *lockTimeout) start() <-chan time.Time {
+	if t.isExpired {
+		return nil
+	}
+	if t.isStarted {
+		return t.timer.C
+	}
+	remaining := time.Until(t.deadline)
+	if remaining <= 0 {
+		t.isExpired = true
+		return nil
+	}
+	if t.timer == nil {
+		t.timer = time.NewTimer(remaining)
+	} else {
+		t.timer.Reset(remaining)
+	}
+	t.isStarted = true
+	return t.timer.C
+}
+
+// wait waits until waiter "w" is woken up or the timeout is expired.
+//
+// Returns `false` if the timeout expired before the waiter was woken up.
+func (t *lockTimeout) wait(w *lockWaiter) bool {
+	timerC := t.start()
+	if timerC == nil {
+		return false
+	}
+	select {
+	case <-w.done:
+		return true
+	case <-timerC:
+		t.isExpired = true
+		return false
+	}
+}
+
+// Deadline implements context.Context.
+func (t *lockTimeout) Deadline() (time.Time, bool) {
+	return t.deadline, true
+}
+
+// Done implements context.Context.
+func (t *lockTimeout) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context.
+func (t *lockTimeout) Err() error {
+	if t.isExpired {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// Value implements context.Context.
+func (t *lockTimeout) Value(any) any {
+	return nil
+}
+
+// LockTimeout is analog of LockCtx(), but allows to continue the try to lock
+// only until the timeout is expired. It is cheaper than LockCtx with
+// a context.WithTimeout: it does not allocate a context, and uses a pooled
+// timer.
+//
+// Returns `false` if was unable to lock (the timeout expired before it was possible to lock).
+func (m *Mutex) LockTimeout(timeout time.Duration) bool {
+	t := newLockTimeout(timeout)
+	defer t.release()
+	return m.lock(t, GetGoroutineID(), true)
+}
+
+// LockTimeoutDo is a wrapper around LockTimeout and Unlock.
+//
+// If fn panics, then the lock is released anyway (as by LockDo).
+//
+// See also LockDo and LockTimeout.
+func (m *Mutex) LockTimeoutDo(timeout time.Duration, fn func()) (success bool) {
+	if !m.LockTimeout(timeout) {
+		return false
+	}
+	defer m.Unlock()
+
+	success = true
+	fn()
+	return
+}
+
+// LockTimeout is analog of LockCtx(), but allows to continue the try to lock
+// only until the timeout is expired. It is cheaper than LockCtx with
+// a context.WithTimeout: it does not allocate a context, and uses a pooled
+// timer.
+//
+// Returns `false` if was unable to lock (the timeout expired before it was possible to lock).
+func (m *RWMutex) LockTimeout(timeout time.Duration) bool {
+	t := newLockTimeout(timeout)
+	defer t.release()
+	return m.lock(t, GetGoroutineID(), true)
+}
+
+// LockTimeoutDo is a wrapper around LockTimeout and Unlock.
+//
+// If fn panics, then the lock is released anyway (as by LockDo).
+//
+// See also LockDo and LockTimeout.
+func (m *RWMutex) LockTimeoutDo(timeout time.Duration, fn func()) (success bool) {
+	if !m.LockTimeout(timeout) {
+		return false
+	}
+	defer m.Unlock()
+
+	success = true
+	fn()
+	return
+}
+
+// RLockTimeout is analog of RLockCtx(), but allows to continue the try to lock
+// only until the timeout is expired (see LockTimeout).
+//
+// Returns `false` if was unable to lock (the timeout expired before it was possible to lock).
+func (m *RWMutex) RLockTimeout(timeout time.Duration) bool {
+	t := newLockTimeout(timeout)
+	defer t.release()
+	return m.rLock(t, GetGoroutineID(), true)
+}
+
+// RLockTimeoutDo is a wrapper around RLockTimeout and RUnlock.
+//
+// If fn panics, then the lock is released anyway (as by RLockDo).
+//
+// See also RLockDo and RLockTimeout.
+func (m *RWMutex) RLockTimeoutDo(timeout time.Duration, fn func()) (success bool) {
+	if !m.RLockTimeout(timeout) {
+		return false
+	}
+	defer m.RUnlock()
+
+	success = true
+	fn()
+	return
+}
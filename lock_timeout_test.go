@@ -0,0 +1,91 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockTimeout(t *testing.T) {
+	t.Run("Mutex", func(t *testing.T) {
+		m := &Mutex{}
+		assert.True(t, m.LockTimeout(time.Hour))
+		assert.True(t, m.LockTimeoutDo(0, func() {}))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.False(t, m.LockTimeout(0))
+			assert.False(t, m.LockTimeoutDo(time.Millisecond, func() {
+				t.Error("should not be called")
+			}))
+		}()
+		wg.Wait()
+		assert.True(t, m.waiters.isEmpty())
+
+		i := 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the timer of the expired timeout above could be reused, it
+			// should not finish this waiting
+			assert.True(t, m.LockTimeoutDo(time.Hour, func() {
+				i = 2
+			}))
+		}()
+		time.Sleep(10 * time.Millisecond)
+		i = 1
+		m.Unlock()
+		wg.Wait()
+		assert.Equal(t, 2, i)
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		m := &RWMutex{}
+		assert.True(t, m.RLockTimeout(time.Hour))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.False(t, m.LockTimeout(time.Millisecond))
+			assert.True(t, m.RLockTimeoutDo(time.Millisecond, func() {}))
+		}()
+		wg.Wait()
+
+		// the same goroutine could lock for writing
+		assert.True(t, m.LockTimeoutDo(time.Millisecond, func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.False(t, m.RLockTimeout(time.Millisecond))
+				assert.False(t, m.LockTimeoutDo(time.Millisecond, func() {}))
+			}()
+			wg.Wait()
+		}))
+		m.RUnlock()
+		assert.True(t, m.waiters.isEmpty())
+	})
+	t.Run("allocations", func(t *testing.T) {
+		m := &Mutex{}
+		lockAllocs := testing.AllocsPerRun(100, func() {
+			m.Lock()
+			m.Unlock()
+		})
+		timeoutAllocs := testing.AllocsPerRun(100, func() {
+			m.LockTimeout(time.Second)
+			m.Unlock()
+		})
+		ctxAllocs := testing.AllocsPerRun(100, func() {
+			ctx, cancelFn := context.WithTimeout(context.Background(), time.Second)
+			m.LockCtx(ctx)
+			m.Unlock()
+			cancelFn()
+		})
+		assert.Equal(t, lockAllocs, timeoutAllocs)
+		assert.Less(t, timeoutAllocs, ctxAllocs)
+	})
+}
@@ -0,0 +1,196 @@
+package gorex
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// wakeUpCount is the total amount of wake-ups of waiting goroutines
+// (it is used to measure the efficiency of the wake-up policy).
+var wakeUpCount uint64
+
+// lockWaiterKind is the kind of the lock a waiter waits for.
+type lockWaiterKind uint8
+
+const (
+	lockWaiterKindWrite = lockWaiterKind(iota)
+	lockWaiterKindRead
+	lockWaiterKindUpgradeable
+	lockWaiterKindPermits
+)
+
+// lockWaiter is a goroutine (or a lock owner, see WithOwner) waiting
+// in the queue of a mutex.
+type lockWaiter struct {
+	// ticket is the sequence number of the waiter in the queue.
+	ticket uint64
+
+	me       GoroutineID
+	kind     lockWaiterKind
+	priority bool
+
+	// permits is the amount of permits the waiter waits for (see Semaphore).
+	permits int64
+
+	// since is the moment the goroutine started to wait.
+	since time.Time
+
+	// stack is the call stack trace where the goroutine started to wait
+	// (see SetAcquisitionStackDepth).
+	stack []uintptr
+
+	// isWoken is set (under the internalLocker of the mutex) when the waiter
+	// is removed from the queue and woken up.
+	isWoken bool
+
+	// isGranted is set (under the internalLocker of the mutex) when the lock
+	// is handed to the waiter (see Mutex.Fair and RWMutex.Fair).
+	isGranted bool
+
+	// isFirstRead is set if a read lock is granted and it is the first
+	// read lock of the waiter (see RWMutex.incMyReadersCount).
+	isFirstRead bool
+
+	done chan struct{}
+}
+
+// wakeUp wakes up the waiter, so it could try to acquire the lock again.
+func (w *lockWaiter) wakeUp() {
+	w.isWoken = true
+	atomic.AddUint64(&wakeUpCount, 1)
+	close(w.done)
+}
+
+// deadlockReporter is a mutex which could report a deadlock (see debugPanic).
+type deadlockReporter interface {
+	debugPanic()
+}
+
+// wait waits until the waiter is woken up or the context is done. If
+// the context is the InfiniteContext of the mutex, then the deadlock is reported
+// (see debugPanic) and the waiting continues without the context (*ctx is
+// replaced, so the next waitings of the goroutine will not report it again).
+//
+// Returns `false` if the context finished before the waiter was woken up.
+func (w *lockWaiter) wait(ctx *context.Context, isInfiniteContext bool, m deadlockReporter) bool {
+	if t, ok := (*ctx).(*lockTimeout); ok {
+		return t.wait(w)
+	}
+	select {
+	case <-w.done:
+		return true
+	case <-(*ctx).Done():
+	}
+	if !isInfiniteContext {
+		return false
+	}
+	m.debugPanic()
+	// The OnDeadlock handler did not panic, so continue waiting.
+	*ctx = context.Background()
+	<-w.done
+	return true
+}
+
+// grant marks the waiter as the owner of the lock and wakes it up.
+func (w *lockWaiter) grant() {
+	w.isGranted = true
+	w.wakeUp()
+}
+
+// lockWaiterQueue is a FIFO queue of waiters ordered by their tickets,
+// except priority waiters, which are placed before others.
+type lockWaiterQueue struct {
+	lastTicket uint64
+	waiters    []*lockWaiter
+}
+
+// push adds a new waiter to the queue.
+//
+// A priority waiter is placed after other priority waiters, but
+// before non-priority waiters.
+//
+// "prev" is the previous waiter of the same goroutine (which was woken up,
+// but did not get the lock) or nil; the waiting is counted since
+// the previous waiter started to wait.
+func (q *lockWaiterQueue) push(me GoroutineID, kind lockWaiterKind, priority bool, prev *lockWaiter) *lockWaiter {
+	q.lastTicket++
+	w := &lockWaiter{
+		ticket:   q.lastTicket,
+		me:       me,
+		kind:     kind,
+		priority: priority,
+		done:     make(chan struct{}),
+	}
+	if prev != nil {
+		w.since = prev.since
+		w.stack = prev.stack
+	} else {
+		w.since = time.Now()
+		w.stack = acquisitionStack(nil, 2)
+	}
+
+	idx := len(q.waiters)
+	if priority {
+		for idx > 0 && !q.waiters[idx-1].priority {
+			idx--
+		}
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[idx+1:], q.waiters[idx:])
+	q.waiters[idx] = w
+	return w
+}
+
+// head returns the first waiter of the queue (or nil if the queue is empty).
+func (q *lockWaiterQueue) head() *lockWaiter {
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	return q.waiters[0]
+}
+
+// pop removes the first waiter of the queue.
+func (q *lockWaiterQueue) pop() {
+	q.removeAt(0)
+}
+
+// remove removes the waiter from the queue (if it is in the queue).
+func (q *lockWaiterQueue) remove(w *lockWaiter) {
+	for idx, waiter := range q.waiters {
+		if waiter == w {
+			q.removeAt(idx)
+			return
+		}
+	}
+}
+
+// removeAt removes the waiter with index "idx" from the queue.
+func (q *lockWaiterQueue) removeAt(idx int) {
+	copy(q.waiters[idx:], q.waiters[idx+1:])
+	q.waiters[len(q.waiters)-1] = nil
+	q.waiters = q.waiters[:len(q.waiters)-1]
+	if len(q.waiters) == 0 {
+		q.waiters = nil
+	}
+}
+
+// deadlockWaiters returns the information about the waiters of the queue.
+func (q *lockWaiterQueue) deadlockWaiters() []DeadlockWaiter {
+	var result []DeadlockWaiter
+	for _, w := range q.waiters {
+		result = append(result, DeadlockWaiter{
+			GoroutineID: w.me,
+			IsWrite:     w.kind == lockWaiterKindWrite || w.kind == lockWaiterKindUpgradeable,
+			Permits:     w.permits,
+			Since:       w.since,
+			Stack:       stackFrames(w.stack),
+		})
+	}
+	return result
+}
+
+// isEmpty returns true if nobody waits in the queue.
+func (q *lockWaiterQueue) isEmpty() bool {
+	return len(q.waiters) == 0
+}
@@ -0,0 +1,110 @@
+package gorex
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives the statistics of locking of Mutex and RWMutex (see
+// their field Metrics and DefaultMetrics), for example to export them to
+// a monitoring system (see MetricsCollector).
+//
+// "locker" is the *Mutex or the *RWMutex, and "isWrite" is false for read
+// locks. The methods are called synchronously by the locking/unlocking
+// goroutine (but not under the internal lock of the mutex), so they should
+// be fast and safe for concurrent use.
+type Metrics interface {
+	// Acquired is called on every acquisition of a lock which was not
+	// held by the goroutine (see also Reacquired). "isContended" is true
+	// if the goroutine had to wait for the lock, and "wait" is how long
+	// it waited.
+	Acquired(locker sync.Locker, isWrite bool, isContended bool, wait time.Duration)
+
+	// Reacquired is called on every reentrant acquisition of a lock (when
+	// the goroutine already holds it).
+	Reacquired(locker sync.Locker, isWrite bool)
+
+	// Released is called when a lock reported by Acquired is released
+	// (the last level of it), "hold" is how long it was held.
+	Released(locker sync.Locker, isWrite bool, hold time.Duration)
+
+	// TryLockFailed is called when LockTry (or an analog) was unable to lock
+	// right away.
+	TryLockFailed(locker sync.Locker, isWrite bool)
+
+	// TimedOut is called when the context of LockCtx (or an analog) was done
+	// before it was possible to lock, "wait" is how long the goroutine waited.
+	TimedOut(locker sync.Locker, isWrite bool, wait time.Duration)
+}
+
+// DefaultMetrics receives the statistics of locking of the mutexes which
+// do not have their own Metrics.
+//
+// The zero-value means to do not collect the statistics.
+var DefaultMetrics Metrics
+
+// metricsWaitStart remembers the moment the goroutine started to wait
+// for a lock (if the metrics are collected and the moment is not
+// remembered, yet).
+func metricsWaitStart(metrics Metrics, waitStartedAt *time.Time) {
+	if metrics == nil || !waitStartedAt.IsZero() {
+		return
+	}
+	*waitStartedAt = time.Now()
+}
+
+// holdStartTime returns the moment a lock is acquired, or the zero value
+// if nobody needs the hold duration (the metrics are not collected and
+// the execution tracing is disabled, see SetExecutionTracing).
+func holdStartTime(metrics Metrics) time.Time {
+	if metrics == nil && !isExecutionTracingEnabled() {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func metricsAcquired(metrics Metrics, locker sync.Locker, isWrite bool, waitStartedAt time.Time) {
+	if metrics == nil {
+		return
+	}
+	if waitStartedAt.IsZero() {
+		metrics.Acquired(locker, isWrite, false, 0)
+		return
+	}
+	metrics.Acquired(locker, isWrite, true, time.Since(waitStartedAt))
+}
+
+func metricsReacquired(metrics Metrics, locker sync.Locker, isWrite bool) {
+	if metrics == nil {
+		return
+	}
+	metrics.Reacquired(locker, isWrite)
+}
+
+// metricsReleased reports the release of a lock acquired at "heldSince"
+// (see holdStartTime). It does nothing if "heldSince" is zero (the lock
+// is still held, or nobody needed the hold duration when it was acquired).
+func metricsReleased(metrics Metrics, locker sync.Locker, isWrite bool, heldSince time.Time) {
+	if metrics == nil || heldSince.IsZero() {
+		return
+	}
+	metrics.Released(locker, isWrite, time.Since(heldSince))
+}
+
+func metricsTryLockFailed(metrics Metrics, locker sync.Locker, isWrite bool) {
+	if metrics == nil {
+		return
+	}
+	metrics.TryLockFailed(locker, isWrite)
+}
+
+func metricsTimedOut(metrics Metrics, locker sync.Locker, isWrite bool, waitStartedAt time.Time) {
+	if metrics == nil {
+		return
+	}
+	var wait time.Duration
+	if !waitStartedAt.IsZero() {
+		wait = time.Since(waitStartedAt)
+	}
+	metrics.TimedOut(locker, isWrite, wait)
+}
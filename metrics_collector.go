@@ -0,0 +1,340 @@
+package gorex
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMetricsBuckets are the upper bounds of the buckets of the wait
+// and hold duration histograms of MetricsCollector (if it does not have its
+// own Buckets).
+var DefaultMetricsBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// MetricsCollector is an implementation of Metrics, which aggregates
+// the statistics by the names of the mutexes (see Mutex.Name; unnamed
+// mutexes are aggregated together) and by the lock type (read or write).
+//
+// The statistics could be exported with WritePrometheus or Expvar.
+//
+// The zero-value is ready to use:
+//
+//	var metrics gorex.MetricsCollector
+//	gorex.DefaultMetrics = &metrics
+//	expvar.Publish("gorex", metrics.Expvar())
+type MetricsCollector struct {
+	// Buckets are the upper bounds of the buckets of the wait and hold
+	// duration histograms (in increasing order).
+	//
+	// The zero-value means to use DefaultMetricsBuckets. It should not be
+	// changed after the first use.
+	Buckets []time.Duration
+
+	locker sync.RWMutex
+	stats  map[metricsKey]*metricsStats
+}
+
+var _ Metrics = (*MetricsCollector)(nil)
+
+type metricsKey struct {
+	name    string
+	isWrite bool
+}
+
+// metricsStats are the counters of a metricsKey, they are updated atomically.
+type metricsStats struct {
+	acquisitions          uint64
+	reentrantAcquisitions uint64
+	contendedAcquisitions uint64
+	tryLockFailures       uint64
+	timeouts              uint64
+	wait                  durationHistogram
+	hold                  durationHistogram
+}
+
+type durationHistogram struct {
+	sum    int64
+	count  uint64
+	counts []uint64
+}
+
+func (h *durationHistogram) observe(buckets []time.Duration, d time.Duration) {
+	idx := sort.Search(len(buckets), func(i int) bool {
+		return d <= buckets[i]
+	})
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+	atomic.AddUint64(&h.count, 1)
+}
+
+func (h *durationHistogram) snapshot(buckets []time.Duration) DurationHistogram {
+	result := DurationHistogram{
+		Buckets: buckets,
+		Counts:  make([]uint64, len(h.counts)),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+		Count:   atomic.LoadUint64(&h.count),
+	}
+	for idx := range h.counts {
+		result.Counts[idx] = atomic.LoadUint64(&h.counts[idx])
+	}
+	return result
+}
+
+// lockerName returns the name of the mutex (see Mutex.Name).
+func lockerName(locker sync.Locker) string {
+	switch locker := locker.(type) {
+	case *Mutex:
+		return locker.Name
+	case *RWMutex:
+		return locker.Name
+	}
+	return ""
+}
+
+func (c *MetricsCollector) buckets() []time.Duration {
+	if c.Buckets == nil {
+		return DefaultMetricsBuckets
+	}
+	return c.Buckets
+}
+
+func (c *MetricsCollector) statsOf(locker sync.Locker, isWrite bool) *metricsStats {
+	key := metricsKey{
+		name:    lockerName(locker),
+		isWrite: isWrite,
+	}
+
+	c.locker.RLock()
+	stats := c.stats[key]
+	c.locker.RUnlock()
+	if stats != nil {
+		return stats
+	}
+
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if stats = c.stats[key]; stats != nil {
+		return stats
+	}
+	if c.stats == nil {
+		c.stats = map[metricsKey]*metricsStats{}
+	}
+	bucketsCount := len(c.buckets()) + 1 // including +Inf
+	stats = &metricsStats{
+		wait: durationHistogram{counts: make([]uint64, bucketsCount)},
+		hold: durationHistogram{counts: make([]uint64, bucketsCount)},
+	}
+	c.stats[key] = stats
+	return stats
+}
+
+// Acquired implements Metrics.
+func (c *MetricsCollector) Acquired(locker sync.Locker, isWrite bool, isContended bool, wait time.Duration) {
+	stats := c.statsOf(locker, isWrite)
+	atomic.AddUint64(&stats.acquisitions, 1)
+	if isContended {
+		atomic.AddUint64(&stats.contendedAcquisitions, 1)
+	}
+	stats.wait.observe(c.buckets(), wait)
+}
+
+// Reacquired implements Metrics.
+func (c *MetricsCollector) Reacquired(locker sync.Locker, isWrite bool) {
+	atomic.AddUint64(&c.statsOf(locker, isWrite).reentrantAcquisitions, 1)
+}
+
+// Released implements Metrics.
+func (c *MetricsCollector) Released(locker sync.Locker, isWrite bool, hold time.Duration) {
+	c.statsOf(locker, isWrite).hold.observe(c.buckets(), hold)
+}
+
+// TryLockFailed implements Metrics.
+func (c *MetricsCollector) TryLockFailed(locker sync.Locker, isWrite bool) {
+	atomic.AddUint64(&c.statsOf(locker, isWrite).tryLockFailures, 1)
+}
+
+// TimedOut implements Metrics.
+func (c *MetricsCollector) TimedOut(locker sync.Locker, isWrite bool, wait time.Duration) {
+	atomic.AddUint64(&c.statsOf(locker, isWrite).timeouts, 1)
+}
+
+// LockerStats are the statistics of the locks of one type (read or write)
+// of the mutexes with the same name (see MetricsCollector).
+type LockerStats struct {
+	// Name is the name of the mutexes (see Mutex.Name).
+	Name string
+
+	// IsWrite is false for the statistics of read locks.
+	IsWrite bool
+
+	// Acquisitions is the amount of acquisitions of the lock which was not
+	// held by the goroutine.
+	Acquisitions uint64
+
+	// ReentrantAcquisitions is the amount of acquisitions of the lock which
+	// was already held by the goroutine.
+	ReentrantAcquisitions uint64
+
+	// ContendedAcquisitions is the amount of Acquisitions which had to wait
+	// for the lock.
+	ContendedAcquisitions uint64
+
+	// TryLockFailures is the amount of failed tries to lock without waiting
+	// (see LockTry).
+	TryLockFailures uint64
+
+	// Timeouts is the amount of tries to lock which failed because
+	// the context was done (see LockCtx).
+	Timeouts uint64
+
+	// Wait is the histogram of durations of waiting for the lock (of every
+	// acquisition, zero for not contended ones).
+	Wait DurationHistogram
+
+	// Hold is the histogram of durations of holding the lock.
+	Hold DurationHistogram
+}
+
+// DurationHistogram is a histogram of durations.
+type DurationHistogram struct {
+	// Buckets are the upper bounds of the buckets (inclusive).
+	Buckets []time.Duration
+
+	// Counts are the amounts of durations in each bucket (not cumulative).
+	// The last item is the amount of durations greater than the last bucket.
+	Counts []uint64
+
+	// Sum is the sum of all the durations.
+	Sum time.Duration
+
+	// Count is the amount of all the durations.
+	Count uint64
+}
+
+// Stats returns the collected statistics (sorted by the names, read locks
+// go first).
+func (c *MetricsCollector) Stats() []LockerStats {
+	buckets := c.buckets()
+
+	c.locker.RLock()
+	defer c.locker.RUnlock()
+	result := make([]LockerStats, 0, len(c.stats))
+	for key, stats := range c.stats {
+		result = append(result, LockerStats{
+			Name:                  key.name,
+			IsWrite:               key.isWrite,
+			Acquisitions:          atomic.LoadUint64(&stats.acquisitions),
+			ReentrantAcquisitions: atomic.LoadUint64(&stats.reentrantAcquisitions),
+			ContendedAcquisitions: atomic.LoadUint64(&stats.contendedAcquisitions),
+			TryLockFailures:       atomic.LoadUint64(&stats.tryLockFailures),
+			Timeouts:              atomic.LoadUint64(&stats.timeouts),
+			Wait:                  stats.wait.snapshot(buckets),
+			Hold:                  stats.hold.snapshot(buckets),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return !result[i].IsWrite && result[j].IsWrite
+	})
+	return result
+}
+
+// Expvar returns the statistics as an expvar.Var (see Stats), for example:
+//
+//	expvar.Publish("gorex", metrics.Expvar())
+func (c *MetricsCollector) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return c.Stats()
+	})
+}
+
+// WritePrometheus writes the statistics in the Prometheus text exposition
+// format to "out" (for example to serve them on "/metrics" next to the
+// metrics of the Prometheus client).
+//
+// The metrics are prefixed with "gorex_" and have labels "name" (the name
+// of the mutexes) and "lock" ("read" or "write").
+func (c *MetricsCollector) WritePrometheus(out io.Writer) error {
+	stats := c.Stats()
+	w := bufio.NewWriter(out)
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(*LockerStats) uint64
+	}{
+		{"acquisitions_total", "The amount of acquisitions of locks which were not held by the goroutine.", func(s *LockerStats) uint64 { return s.Acquisitions }},
+		{"reentrant_acquisitions_total", "The amount of acquisitions of locks which were already held by the goroutine.", func(s *LockerStats) uint64 { return s.ReentrantAcquisitions }},
+		{"contended_acquisitions_total", "The amount of acquisitions which had to wait for the lock.", func(s *LockerStats) uint64 { return s.ContendedAcquisitions }},
+		{"try_lock_failures_total", "The amount of failed tries to lock without waiting.", func(s *LockerStats) uint64 { return s.TryLockFailures }},
+		{"timeouts_total", "The amount of tries to lock which failed because the context was done.", func(s *LockerStats) uint64 { return s.Timeouts }},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(w, "# HELP gorex_%s %s\n", counter.name, counter.help)
+		fmt.Fprintf(w, "# TYPE gorex_%s counter\n", counter.name)
+		for idx := range stats {
+			fmt.Fprintf(w, "gorex_%s{%s} %d\n", counter.name, prometheusLabels(&stats[idx]), counter.value(&stats[idx]))
+		}
+	}
+
+	histograms := []struct {
+		name  string
+		help  string
+		value func(*LockerStats) *DurationHistogram
+	}{
+		{"wait_seconds", "The durations of waiting for locks.", func(s *LockerStats) *DurationHistogram { return &s.Wait }},
+		{"hold_seconds", "The durations of holding locks.", func(s *LockerStats) *DurationHistogram { return &s.Hold }},
+	}
+	for _, histogram := range histograms {
+		fmt.Fprintf(w, "# HELP gorex_%s %s\n", histogram.name, histogram.help)
+		fmt.Fprintf(w, "# TYPE gorex_%s histogram\n", histogram.name)
+		for idx := range stats {
+			labels := prometheusLabels(&stats[idx])
+			h := histogram.value(&stats[idx])
+			var cumulative uint64
+			for bucketIdx, count := range h.Counts {
+				cumulative += count
+				le := "+Inf"
+				if bucketIdx < len(h.Buckets) {
+					le = prometheusFloat(h.Buckets[bucketIdx].Seconds())
+				}
+				fmt.Fprintf(w, "gorex_%s_bucket{%s,le=%q} %d\n", histogram.name, labels, le, cumulative)
+			}
+			fmt.Fprintf(w, "gorex_%s_sum{%s} %s\n", histogram.name, labels, prometheusFloat(h.Sum.Seconds()))
+			fmt.Fprintf(w, "gorex_%s_count{%s} %d\n", histogram.name, labels, h.Count)
+		}
+	}
+
+	return w.Flush()
+}
+
+func prometheusLabels(stats *LockerStats) string {
+	lockType := "read"
+	if stats.IsWrite {
+		lockType = "write"
+	}
+	return fmt.Sprintf(`name="%s",lock="%s"`, prometheusLabelEscaper.Replace(stats.Name), lockType)
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func prometheusFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,71 @@
+package gorex
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	metrics := &MetricsCollector{
+		Buckets: []time.Duration{time.Millisecond, time.Second},
+	}
+	m := &RWMutex{Name: `TestMetricsCollector "quoted"`, Metrics: metrics}
+	m.LockDo(func() {
+		m.LockDo(func() {})
+	})
+	m.RLockDo(func() {})
+	metrics.Acquired(m, true, true, 2*time.Second)
+	metrics.TryLockFailed(m, false)
+	metrics.TimedOut(m, false, time.Millisecond)
+
+	stats := metrics.Stats()
+	if !assert.Len(t, stats, 2) {
+		return
+	}
+	read, write := stats[0], stats[1]
+	assert.Equal(t, m.Name, read.Name)
+	assert.False(t, read.IsWrite)
+	assert.Equal(t, uint64(1), read.Acquisitions)
+	assert.Equal(t, uint64(1), read.TryLockFailures)
+	assert.Equal(t, uint64(1), read.Timeouts)
+	assert.Equal(t, uint64(1), read.Hold.Count)
+
+	assert.True(t, write.IsWrite)
+	assert.Equal(t, uint64(2), write.Acquisitions)
+	assert.Equal(t, uint64(1), write.ReentrantAcquisitions)
+	assert.Equal(t, uint64(1), write.ContendedAcquisitions)
+	assert.Equal(t, []uint64{1, 0, 1}, write.Wait.Counts)
+	assert.Equal(t, uint64(2), write.Wait.Count)
+	assert.Equal(t, 2*time.Second, write.Wait.Sum)
+
+	t.Run("WritePrometheus", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, metrics.WritePrometheus(&buf))
+		out := buf.String()
+		for _, line := range []string{
+			`# TYPE gorex_acquisitions_total counter`,
+			`gorex_acquisitions_total{name="TestMetricsCollector \"quoted\"",lock="write"} 2`,
+			`gorex_reentrant_acquisitions_total{name="TestMetricsCollector \"quoted\"",lock="write"} 1`,
+			`gorex_try_lock_failures_total{name="TestMetricsCollector \"quoted\"",lock="read"} 1`,
+			`# TYPE gorex_wait_seconds histogram`,
+			`gorex_wait_seconds_bucket{name="TestMetricsCollector \"quoted\"",lock="write",le="0.001"} 1`,
+			`gorex_wait_seconds_bucket{name="TestMetricsCollector \"quoted\"",lock="write",le="1"} 1`,
+			`gorex_wait_seconds_bucket{name="TestMetricsCollector \"quoted\"",lock="write",le="+Inf"} 2`,
+			`gorex_wait_seconds_sum{name="TestMetricsCollector \"quoted\"",lock="write"} 2`,
+			`gorex_wait_seconds_count{name="TestMetricsCollector \"quoted\"",lock="write"} 2`,
+			`gorex_hold_seconds_count{name="TestMetricsCollector \"quoted\"",lock="read"} 1`,
+		} {
+			assert.True(t, strings.Contains(out, line+"\n"), line)
+		}
+	})
+	t.Run("Expvar", func(t *testing.T) {
+		var result []LockerStats
+		assert.NoError(t, json.Unmarshal([]byte(metrics.Expvar().String()), &result))
+		assert.Equal(t, stats, result)
+	})
+}
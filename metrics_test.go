@@ -0,0 +1,169 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metricsRecorder is a Metrics which records the events as strings.
+type metricsRecorder struct {
+	locker sync.Mutex
+	events []string
+}
+
+func (r *metricsRecorder) record(event string, isWrite bool) {
+	lockType := "read"
+	if isWrite {
+		lockType = "write"
+	}
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	r.events = append(r.events, event+" "+lockType)
+}
+
+func (r *metricsRecorder) Acquired(_ sync.Locker, isWrite bool, isContended bool, wait time.Duration) {
+	if isContended {
+		r.record("contended", isWrite)
+		return
+	}
+	r.record("acquired", isWrite)
+}
+
+func (r *metricsRecorder) Reacquired(_ sync.Locker, isWrite bool) {
+	r.record("reacquired", isWrite)
+}
+
+func (r *metricsRecorder) Released(_ sync.Locker, isWrite bool, hold time.Duration) {
+	r.record("released", isWrite)
+}
+
+func (r *metricsRecorder) TryLockFailed(_ sync.Locker, isWrite bool) {
+	r.record("tryLockFailed", isWrite)
+}
+
+func (r *metricsRecorder) TimedOut(_ sync.Locker, isWrite bool, wait time.Duration) {
+	r.record("timedOut", isWrite)
+}
+
+func (r *metricsRecorder) flush() []string {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestMetrics(t *testing.T) {
+	timeoutCtx := func() context.Context {
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		t.Cleanup(cancelFn)
+		return ctx
+	}
+
+	t.Run("Mutex", func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		m := &Mutex{Metrics: metrics}
+		m.LockDo(func() {
+			m.LockDo(func() {})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.False(t, m.LockTry())
+				assert.False(t, m.LockCtx(timeoutCtx()))
+			}()
+			wg.Wait()
+		})
+		assert.Equal(t, []string{
+			"acquired write",
+			"reacquired write",
+			"tryLockFailed write",
+			"timedOut write",
+			"released write",
+		}, metrics.flush())
+
+		var wg sync.WaitGroup
+		m.LockDo(func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.LockDo(func() {})
+			}()
+			for len(m.Waiters()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		})
+		wg.Wait()
+		assert.ElementsMatch(t, []string{
+			"acquired write",
+			"released write",
+			"contended write",
+			"released write",
+		}, metrics.flush())
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		m := &RWMutex{Metrics: metrics}
+		m.RLockDo(func() {
+			m.RLockDo(func() {})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.False(t, m.LockTry())
+				assert.False(t, m.LockCtx(timeoutCtx()))
+			}()
+			wg.Wait()
+		})
+		assert.Equal(t, []string{
+			"acquired read",
+			"reacquired read",
+			"tryLockFailed write",
+			"timedOut write",
+			"released read",
+		}, metrics.flush())
+
+		m.Lock()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.False(t, m.RLockTry())
+			m.RLockDo(func() {})
+		}()
+		for len(m.Waiters()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		m.Downgrade()
+		wg.Wait()
+		m.RUnlock()
+		assert.ElementsMatch(t, []string{
+			"acquired write",
+			"tryLockFailed read",
+			"acquired read",
+			"released write",
+			"contended read",
+			"released read",
+			"released read",
+		}, metrics.flush())
+	})
+	t.Run("DefaultMetrics", func(t *testing.T) {
+		metrics := &metricsRecorder{}
+		DefaultMetrics = metrics
+		defer func() {
+			DefaultMetrics = nil
+		}()
+
+		m := &Mutex{}
+		m.LockDo(func() {})
+		assert.Equal(t, []string{"acquired write", "released write"}, metrics.flush())
+
+		m.Metrics = &metricsRecorder{}
+		m.LockDo(func() {})
+		assert.Empty(t, metrics.flush())
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/xaionaro-go/spinlock"
 )
@@ -21,8 +22,10 @@ var DefaultInfiniteContext = context.Background()
 type ProgramCounter = int
 
 const (
-	// MaxStackTrace is the maximal depth of the stacktrace stored in the locker
+	// MaxStackTrace is the default depth of the stacktrace stored in the locker
 	// (that is used during panics if a deadlock was reached).
+	//
+	// See also SetAcquisitionStackDepth.
 	MaxStackTrace = 4
 )
 
@@ -30,6 +33,14 @@ const (
 // the same way as sync.Mutex, but tracks which goroutine locked
 // it. So it could be locked multiple times with the same routine.
 type Mutex struct {
+	// Name is a human-readable name of the mutex. A mutex with a non-empty
+	// Name is registered (on the first lock) in the global registry of
+	// mutexes (see RegisteredLockers), so its state could be inspected
+	// while debugging (see package "debughttp").
+	//
+	// It should not be changed after the first lock.
+	Name string
+
 	// InfiniteContext is used as the default context used on any try to lock if
 	// a custom context is not set (see LockCtx), but with the difference
 	// if this context will be done, then it will panic with debugging information.
@@ -39,18 +50,55 @@ type Mutex struct {
 	// The zero-value means to use DefaultInfiniteContext.
 	InfiniteContext context.Context
 
-	backendLocker          sync.Mutex
-	internalLocker         spinlock.Locker
-	monopolizedBy          GoroutineID
-	monopolizedDepth       int
-	monopolizedStackStrace [MaxStackTrace]ProgramCounter
-	lockDone               chan struct{}
+	// OnDeadlock is called when InfiniteContext is done. If the handler
+	// returns, then the goroutine continues to wait for the lock.
+	//
+	// The zero-value means to use the package-level OnDeadlock.
+	OnDeadlock func(*DeadlockReport)
+
+	// HoldWarnThreshold is the maximal duration the lock could be held
+	// by a goroutine before reporting it via OnLongHold.
+	//
+	// The zero-value means to use DefaultHoldWarnThreshold, a negative
+	// value disables the watching.
+	HoldWarnThreshold time.Duration
+
+	// OnLongHold is called when the lock is held longer than
+	// HoldWarnThreshold.
+	//
+	// The zero-value means to use the package-level OnLongHold.
+	OnLongHold func(*LongHoldReport)
+
+	// Fair enables the FIFO mode: Unlock hands the lock directly to
+	// the goroutine which waits for the longest time (otherwise it only
+	// wakes the goroutine up, and the goroutine races with others), so
+	// a goroutine cannot be overtaken by goroutines which started to wait
+	// later. It is slower, but it prevents starvation.
+	//
+	// It should not be changed while the mutex is in use.
+	Fair bool
+
+	// Metrics receives the statistics of locking of the mutex (acquisitions,
+	// wait and hold durations, etc).
+	//
+	// The zero-value means to use DefaultMetrics.
+	Metrics Metrics
+
+	backendLocker    sync.Mutex
+	internalLocker   spinlock.Locker
+	monopolizedBy    GoroutineID
+	monopolizedDepth int
+	monopolizedStack []uintptr
+	monopolizedAt    time.Time
+	holdWatchdog     holdWatchdog
+	waiters          lockWaiterQueue
+	isRegistered     uint32
 }
 
 // Lock is analog of `(*sync.Mutex)`.Lock, but it allows one goroutine
 // to call it multiple times without calling Unlock.
 func (m *Mutex) Lock() {
-	m.lock(nil, true)
+	m.lock(nil, GetGoroutineID(), true)
 }
 
 // LockTry is analog of Lock(), but it does not block if it cannot lock
@@ -58,14 +106,18 @@ func (m *Mutex) Lock() {
 //
 // Returns `false` if was unable to lock.
 func (m *Mutex) LockTry() bool {
-	return m.lock(nil, false)
+	return m.lock(nil, GetGoroutineID(), false)
 }
 
 // LockCtx is analog of Lock(), but allows to continue the try to lock only until context is done..
 //
+// If the context carries a lock owner (see WithOwner), then the lock is
+// acquired by this owner instead of the calling goroutine (and it should be
+// released by UnlockCtx with a context carrying the same owner).
+//
 // Returns `false` if was unable to lock (context finished before it was possible to lock).
 func (m *Mutex) LockCtx(ctx context.Context) bool {
-	return m.lock(ctx, true)
+	return m.lock(ctx, lockOwnerID(ctx), true)
 }
 
 func (m *Mutex) infiniteContext() context.Context {
@@ -75,54 +127,139 @@ func (m *Mutex) infiniteContext() context.Context {
 	return m.InfiniteContext
 }
 
-func (m *Mutex) lock(ctx context.Context, shouldWait bool) bool {
-	me := GetGoroutineID()
+func (m *Mutex) metrics() Metrics {
+	if m.Metrics == nil {
+		return DefaultMetrics
+	}
+	return m.Metrics
+}
 
+func (m *Mutex) lock(ctx context.Context, me GoroutineID, shouldWait bool) bool {
+	m.register()
+	if shouldWait {
+		lockOrderBeforeLock(m, me, true)
+	}
+	metrics := m.metrics()
+	isInfiniteContext := false
+	if ctx == nil {
+		ctx = m.infiniteContext()
+		isInfiniteContext = true
+	}
+
+	var waitStartedAt time.Time
+	var w *lockWaiter
+	m.internalLocker.Lock()
 	for {
-		m.internalLocker.Lock()
-		switch m.monopolizedBy {
-		case 0:
-			m.monopolizedBy = me
-			m.monopolizedDepth++
-			goroutineOpenedLock(m, true)
-			m.internalLocker.Unlock()
-			m.backendLocker.Lock()
-			return true
-		case me:
+		if m.monopolizedBy == me {
 			m.monopolizedDepth++
 			m.internalLocker.Unlock()
+			lockOrderLocked(m, me, true)
+			metricsReacquired(metrics, m, true)
 			return true
 		}
+		if m.monopolizedBy == 0 {
+			m.monopolizedBy = me
+			m.monopolizedDepth = 1
+			break
+		}
 		if !shouldWait {
 			m.internalLocker.Unlock()
+			metricsTryLockFailed(metrics, m, true)
 			return false
 		}
-		var ch chan struct{}
-		if m.lockDone == nil {
-			m.lockDone = make(chan struct{})
-		}
-		ch = m.lockDone
-		isInfiniteContext := false
-		if ctx == nil {
-			ctx = m.infiniteContext()
-			isInfiniteContext = true
-		}
-		m.internalLocker.Unlock()
-		select {
-		case <-ch:
-		case <-ctx.Done():
-			if isInfiniteContext {
-				m.debugPanic()
-			}
+		w = m.waiters.push(me, lockWaiterKindWrite, false, w)
+		if !m.wait(&ctx, w, isInfiniteContext, &waitStartedAt) {
+			contentionRecord(waitStartedAt)
+			metricsTimedOut(metrics, m, true, waitStartedAt)
 			return false
 		}
+		if w.isGranted {
+			// monopolizedBy and monopolizedDepth are already set by wakeUpWaiter
+			break
+		}
 	}
+	m.monopolizedStack = acquisitionStack(m.monopolizedStack, 2)
+	m.monopolizedAt = holdStartTime(metrics)
+	m.holdWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, true, m.monopolizedStack)
+	goroutineOpenedLock(m, me, true)
+	m.internalLocker.Unlock()
+	m.backendLocker.Lock()
+	lockOrderLocked(m, me, true)
+	contentionRecord(waitStartedAt)
+	metricsAcquired(metrics, m, true, waitStartedAt)
+	traceHoldStart(m.Name)
+	return true
+}
+
+// wait waits until waiter "w" (which is already queued) is woken up. It should
+// be called with locked internalLocker.
+//
+// Returns `true` (with locked internalLocker) if the waiter was woken up, or
+// `false` (with unlocked internalLocker) if context finished before that.
+func (m *Mutex) wait(
+	ctx *context.Context,
+	w *lockWaiter,
+	isInfiniteContext bool,
+	waitStartedAt *time.Time,
+) bool {
+	m.internalLocker.Unlock()
+	metricsWaitStart(m.metrics(), waitStartedAt)
+	contentionWaitStart(waitStartedAt)
+	waitRegion := traceWaitStart(*ctx, m.Name)
+	waitNode := waitForGraphWaiting(m, w, *ctx, isInfiniteContext)
+	isWoken := w.wait(ctx, isInfiniteContext, m)
+	waitForGraphWoken(w.me, waitNode)
+	traceRegionEnd(waitRegion)
+	m.internalLocker.Lock()
+	if isWoken || w.isGranted {
+		return true
+	}
+	if w.isWoken {
+		// the wake-up should not be lost, pass it to another waiter
+		m.wakeUpWaiter()
+	} else {
+		m.waiters.remove(w)
+	}
+	m.internalLocker.Unlock()
+	return false
+}
+
+// wakeUpWaiter wakes up the first waiter of the queue (if the mutex is not
+// locked). In the fair mode (see Fair) the lock is handed to the waiter.
+// It should be called with locked internalLocker.
+func (m *Mutex) wakeUpWaiter() {
+	if m.monopolizedBy != 0 {
+		return
+	}
+	w := m.waiters.head()
+	if w == nil {
+		return
+	}
+	m.waiters.pop()
+	if !m.Fair {
+		w.wakeUp()
+		return
+	}
+	m.monopolizedBy = w.me
+	m.monopolizedDepth = 1
+	w.grant()
 }
 
 // Unlock is analog of `(*sync.Mutex)`.Unlock, but it cannot be called
 // from a routine which does not hold the lock (see `Lock`).
 func (m *Mutex) Unlock() {
-	me := GetGoroutineID()
+	m.unlock(GetGoroutineID())
+}
+
+// UnlockCtx is analog of Unlock(), but for the lock acquired by LockCtx
+// with a context carrying a lock owner (see WithOwner).
+//
+// If the context does not carry a lock owner, then it is the same as Unlock().
+func (m *Mutex) UnlockCtx(ctx context.Context) {
+	m.unlock(lockOwnerID(ctx))
+}
+
+func (m *Mutex) unlock(me GoroutineID) {
 	m.internalLocker.Lock()
 	switch {
 	case m.monopolizedBy == 0:
@@ -132,24 +269,29 @@ func (m *Mutex) Unlock() {
 		m.internalLocker.Unlock()
 		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.monopolizedBy))
 	}
+	var heldSince time.Time
 	m.monopolizedDepth--
 	if m.monopolizedDepth == 0 {
 		m.monopolizedBy = 0
-		goroutineClosedLock(m, true)
+		heldSince = m.monopolizedAt
+		m.monopolizedAt = time.Time{}
+		m.holdWatchdog.stop()
+		goroutineClosedLock(m, me, true)
 		m.backendLocker.Unlock()
+		m.wakeUpWaiter()
 	}
-	chPtr := m.lockDone
-	m.lockDone = nil
 	m.internalLocker.Unlock()
-	if chPtr == nil {
-		return
-	}
-	close(chPtr)
+	lockOrderUnlocked(m, me, true)
+	metricsReleased(m.metrics(), m, true, heldSince)
+	traceHoldEnd(m.Name, heldSince)
 }
 
 // LockDo is a wrapper around Lock and Unlock.
 // It's a handy function to see in the call stack trace which locker where was locked.
 // Also it's handy not to forget to unlock the locker.
+//
+// If fn panics, then the lock is released anyway (only this level of it,
+// if the mutex was locked multiple times), so the mutex stays consistent.
 func (m *Mutex) LockDo(fn func()) {
 	m.Lock()
 	defer m.Unlock()
@@ -159,6 +301,8 @@ func (m *Mutex) LockDo(fn func()) {
 
 // LockTryDo is a wrapper around LockTry and Unlock.
 //
+// If fn panics, then the lock is released anyway (as by LockDo).
+//
 // See also LockDo and LockTry.
 func (m *Mutex) LockTryDo(fn func()) (success bool) {
 	if !m.LockTry() {
@@ -171,22 +315,36 @@ func (m *Mutex) LockTryDo(fn func()) (success bool) {
 	return
 }
 
-// LockCtxDo is a wrapper around LockCtx and Unlock.
+// LockCtxDo is a wrapper around LockCtx and UnlockCtx.
+//
+// If fn panics, then the lock is released anyway (as by LockDo).
 //
 // See also LockDo and LockCtx.
 func (m *Mutex) LockCtxDo(ctx context.Context, fn func()) (success bool) {
 	if !m.LockCtx(ctx) {
 		return false
 	}
-	defer m.Unlock()
+	defer m.UnlockCtx(ctx)
 
 	success = true
 	fn()
 	return
 }
 
-func (m *Mutex) debugPanic() {
+// Waiters returns the goroutines which currently wait for the lock
+// (in the order of waiting).
+func (m *Mutex) Waiters() []DeadlockWaiter {
 	m.internalLocker.Lock()
 	defer m.internalLocker.Unlock()
-	debugPanic(m.monopolizedBy, nil)
+	return m.waiters.deadlockWaiters()
+}
+
+func (m *Mutex) debugPanic() {
+	m.internalLocker.Lock()
+	report := newDeadlockReport(m, m.monopolizedBy, m.monopolizedStack, nil, nil)
+	report.Waiters = m.waiters.deadlockWaiters()
+	onDeadlock := m.OnDeadlock
+	m.internalLocker.Unlock()
+
+	debugPanic(report, onDeadlock)
 }
@@ -83,6 +83,49 @@ func TestMutex(t *testing.T) {
 
 				assert.NotNil(t, result, result)
 			})
+			t.Run("OnDeadlock", func(t *testing.T) {
+				var reports []*DeadlockReport
+				var cancelFn context.CancelFunc
+				locker := &Mutex{
+					OnDeadlock: func(report *DeadlockReport) {
+						reports = append(reports, report)
+					},
+				}
+				locker.InfiniteContext, cancelFn = context.WithDeadline(context.Background(), time.Now())
+				defer cancelFn()
+
+				var wg0, wg1 sync.WaitGroup
+				wg0.Add(1)
+				wg1.Add(1)
+				var owner GoroutineID
+				go locker.LockDo(func() {
+					owner = GetGoroutineID()
+					wg1.Done()
+					wg0.Wait()
+				})
+				wg1.Wait()
+				time.AfterFunc(10*time.Millisecond, wg0.Done)
+				locker.LockDo(func() {})
+
+				if !assert.Len(t, reports, 1) {
+					return
+				}
+				report := reports[0]
+				assert.Equal(t, locker, report.Locker)
+				assert.Equal(t, owner, report.Owner)
+				if assert.NotEmpty(t, report.OwnerStack) {
+					assert.Equal(t, "github.com/xaionaro-go/gorex.(*Mutex).LockDo", report.OwnerStack[0].Function)
+				}
+				if assert.Len(t, report.Waiters, 1) {
+					waiter := report.Waiters[0]
+					assert.Equal(t, GetGoroutineID(), waiter.GoroutineID)
+					assert.True(t, waiter.IsWrite)
+					assert.False(t, waiter.Since.IsZero())
+					assert.NotEmpty(t, waiter.Stack)
+				}
+				assert.Regexp(t, `Goroutine \d+ is waiting for a write lock for \S+ at \S+/mutex_test.go:\d+\.`, report.String())
+				assert.NotEmpty(t, report.Goroutines)
+			})
 		})
 	})
 	t.Run("LockTryDo", func(t *testing.T) {
@@ -143,4 +186,34 @@ func TestMutex(t *testing.T) {
 			wg0.Done()
 		})
 	})
+	t.Run("Waiters", func(t *testing.T) {
+		locker := &Mutex{}
+		assert.Empty(t, locker.Waiters())
+		var wg sync.WaitGroup
+		var waiterID GoroutineID
+		locker.LockDo(func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				waiterID = GetGoroutineID()
+				locker.LockDo(func() {})
+			}()
+			for len(locker.Waiters()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			waiters := locker.Waiters()
+			if assert.Len(t, waiters, 1) {
+				assert.Equal(t, waiterID, waiters[0].GoroutineID)
+				assert.True(t, waiters[0].IsWrite)
+				assert.False(t, waiters[0].Since.IsZero())
+				frame, ok := callerFrame(waiters[0].Stack)
+				if assert.True(t, ok) {
+					assert.True(t, strings.HasSuffix(frame.File, "/mutex_test.go"), frame.File)
+				}
+			}
+		})
+		wg.Wait()
+		assert.Empty(t, locker.Waiters())
+	})
 }
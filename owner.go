@@ -0,0 +1,46 @@
+package gorex
+
+import (
+	"context"
+)
+
+type ownerCtxKey struct{}
+
+// WithOwner returns a copy of the context which carries a new lock owner.
+//
+// By default locks are owned by goroutines, so if an operation spawns helper
+// goroutines (errgroup, worker pools and so on), then they deadlock trying
+// to lock a mutex already locked by the parent goroutine. Locks acquired with
+// a context carrying a lock owner (see LockCtx, RLockCtx and others) are owned
+// by this owner instead, so any goroutine using the context (or a context
+// derived from it) could reenter them:
+//
+//	ctx = gorex.WithOwner(ctx)
+//	m.LockCtxDo(ctx, func() {
+//	    g, ctx := errgroup.WithContext(ctx)
+//	    g.Go(func() error {
+//	        m.LockCtxDo(ctx, func() { // will not get a deadlock here!
+//	            .. some stuff ..
+//	        })
+//	        return nil
+//	    })
+//	    _ = g.Wait()
+//	})
+//
+// Such locks should be released by the Ctx-variants of unlocking methods
+// (UnlockCtx, RUnlockCtx and others) with a context carrying the same owner.
+func WithOwner(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ownerCtxKey{}, newVirtualOwnerID())
+}
+
+// lockOwnerID returns the ID of the owner of the locks acquired with the
+// context: the lock owner carried by the context (see WithOwner) or
+// the calling goroutine.
+func lockOwnerID(ctx context.Context) GoroutineID {
+	if ctx != nil {
+		if owner, ok := ctx.Value(ownerCtxKey{}).(GoroutineID); ok {
+			return owner
+		}
+	}
+	return GetGoroutineID()
+}
@@ -0,0 +1,128 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithOwner(t *testing.T) {
+	// fanOut runs fn in "count" goroutines and waits for them.
+	fanOut := func(count int, fn func()) {
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fn()
+			}()
+		}
+		wg.Wait()
+	}
+	timeoutCtx := func() context.Context {
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		t.Cleanup(cancelFn)
+		return ctx
+	}
+
+	t.Run("Mutex", func(t *testing.T) {
+		locker := &Mutex{}
+		ctx := WithOwner(context.Background())
+		assert.True(t, locker.LockCtx(ctx))
+		assert.False(t, locker.LockTry())
+		assert.Panics(t, locker.Unlock)
+		assert.False(t, locker.LockCtx(WithOwner(timeoutCtx())))
+
+		childCtx, cancelFn := context.WithCancel(ctx)
+		defer cancelFn()
+		// the goroutines of the same owner are not excluded from each other
+		var i int64
+		fanOut(10, func() {
+			locker.LockCtxDo(childCtx, func() {
+				atomic.AddInt64(&i, 1)
+			})
+		})
+		assert.Equal(t, int64(10), i)
+
+		locker.UnlockCtx(ctx)
+		assert.Panics(t, func() { locker.UnlockCtx(ctx) })
+		assert.True(t, locker.LockTry())
+		locker.Unlock()
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		t.Run("write", func(t *testing.T) {
+			locker := &RWMutex{}
+			ctx := WithOwner(context.Background())
+			var i int64
+			assert.True(t, locker.LockCtxDo(ctx, func() {
+				assert.False(t, locker.RLockTry())
+				assert.Panics(t, locker.Unlock)
+				fanOut(10, func() {
+					locker.LockCtxDo(ctx, func() {
+						atomic.AddInt64(&i, 1)
+					})
+					locker.RLockCtxDo(ctx, func() {
+						assert.NotZero(t, atomic.LoadInt64(&i))
+					})
+				})
+			}))
+			assert.Equal(t, int64(10), i)
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("read", func(t *testing.T) {
+			locker := &RWMutex{}
+			ctx := WithOwner(context.Background())
+			assert.True(t, locker.RLockCtx(ctx))
+			assert.Panics(t, locker.RUnlock)
+			fanOut(10, func() {
+				// the readers of the same owner do not block
+				// the owner's writer
+				assert.True(t, locker.LockCtx(ctx))
+				locker.DowngradeCtx(ctx)
+				locker.RUnlockCtx(ctx)
+			})
+			assert.False(t, locker.LockCtx(timeoutCtx()))
+			locker.RUnlockCtx(ctx)
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("upgradeable", func(t *testing.T) {
+			locker := &RWMutex{}
+			ctx := WithOwner(context.Background())
+			assert.True(t, locker.UpgradeableRLockCtx(ctx))
+			fanOut(1, func() {
+				assert.True(t, locker.UpgradeCtx(ctx))
+				locker.DowngradeCtx(ctx)
+			})
+			locker.UpgradeableRUnlockCtx(ctx)
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+	})
+	t.Run("without_owner", func(t *testing.T) {
+		locker := &Mutex{}
+		ctx := context.Background()
+		assert.True(t, locker.LockCtx(ctx))
+		fanOut(1, func() {
+			assert.False(t, locker.LockCtx(timeoutCtx()))
+		})
+		locker.UnlockCtx(ctx)
+	})
+	t.Run("Cond", func(t *testing.T) {
+		locker := &Mutex{}
+		cond := NewCond(locker)
+		ctx := WithOwner(context.Background())
+		assert.True(t, locker.LockCtx(ctx))
+		waitCtx, cancelFn := context.WithTimeout(ctx, time.Millisecond)
+		defer cancelFn()
+		assert.False(t, cond.WaitCtx(waitCtx))
+		locker.UnlockCtx(ctx)
+		assert.True(t, locker.LockTry())
+		locker.Unlock()
+	})
+}
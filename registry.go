@@ -0,0 +1,107 @@
+package gorex
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// LockerInfo is a snapshot of the state of a mutex registered in the global
+// registry (see Mutex.Name and RegisteredLockers).
+type LockerInfo struct {
+	// Locker is the mutex (*Mutex or *RWMutex).
+	Locker sync.Locker `json:"-"`
+
+	// Name is the name of the mutex (see Mutex.Name).
+	Name string
+
+	// Type is the type of the mutex: "Mutex" or "RWMutex".
+	Type string
+
+	// Owner is the ID of goroutine which holds the (write) lock, or zero.
+	Owner GoroutineID `json:",omitempty"`
+
+	// Depth is how many times Owner acquired the (write) lock.
+	Depth int `json:",omitempty"`
+
+	// OwnerStack is the call stack trace where Owner acquired the lock.
+	OwnerStack []StackFrame `json:",omitempty"`
+
+	// Readers is the list of goroutines which hold a read lock.
+	Readers []DeadlockReader `json:",omitempty"`
+
+	// Waiters is the list of goroutines which wait for the lock.
+	Waiters []DeadlockWaiter `json:",omitempty"`
+}
+
+// registeredLocker is a mutex which could be registered in the global
+// registry.
+type registeredLocker interface {
+	lockerInfo() *LockerInfo
+}
+
+// RegisteredLockers returns the state of every alive mutex with
+// a non-empty Name (sorted by the names).
+//
+// The registry does not prevent the mutexes from being garbage collected
+// (it requires Go 1.24+, the registry is always empty otherwise).
+func RegisteredLockers() []*LockerInfo {
+	lockers := registeredLockers()
+	result := make([]*LockerInfo, 0, len(lockers))
+	for _, l := range lockers {
+		result = append(result, l.lockerInfo())
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// register registers the mutex in the global registry if it has a Name
+// (and it is not registered, yet).
+func (m *Mutex) register() {
+	if m.Name == "" || atomic.LoadUint32(&m.isRegistered) != 0 {
+		return
+	}
+	if !atomic.CompareAndSwapUint32(&m.isRegistered, 0, 1) {
+		return
+	}
+	registerMutex(m)
+}
+
+func (m *Mutex) lockerInfo() *LockerInfo {
+	m.internalLocker.Lock()
+	defer m.internalLocker.Unlock()
+
+	info := &LockerInfo{
+		Locker:  m,
+		Name:    m.Name,
+		Type:    "Mutex",
+		Owner:   m.monopolizedBy,
+		Depth:   m.monopolizedDepth,
+		Waiters: m.waiters.deadlockWaiters(),
+	}
+	if info.Owner != 0 {
+		info.OwnerStack = stackFrames(m.monopolizedStack)
+	}
+	return info
+}
+
+func (m *RWMutex) lockerInfo() *LockerInfo {
+	m.internalLocker.Lock()
+	defer m.internalLocker.Unlock()
+
+	info := &LockerInfo{
+		Locker:  m,
+		Name:    m.Name,
+		Type:    "RWMutex",
+		Owner:   m.lockedBy,
+		Depth:   m.lockCount,
+		Readers: deadlockReaders(m.usedBy, m.usedByInfo),
+		Waiters: m.waiters.deadlockWaiters(),
+	}
+	if info.Owner != 0 {
+		info.OwnerStack = stackFrames(m.lockedByStack)
+	}
+	return info
+}
@@ -0,0 +1,11 @@
+//go:build !go1.24
+// +build !go1.24
+
+package gorex
+
+// Weak pointers are required to do not keep registered mutexes alive,
+// so the registry is disabled before Go 1.24.
+
+func registerMutex(*Mutex)                  {}
+func registerRWMutex(*RWMutex)              {}
+func registeredLockers() []registeredLocker { return nil }
@@ -0,0 +1,87 @@
+//go:build go1.24
+// +build go1.24
+
+package gorex
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisteredLockers(t *testing.T) {
+	// find returns the info about the registered mutex with the name.
+	find := func(name string) *LockerInfo {
+		for _, info := range RegisteredLockers() {
+			if info.Name == name {
+				return info
+			}
+		}
+		return nil
+	}
+
+	t.Run("Mutex", func(t *testing.T) {
+		m := &Mutex{Name: "TestRegisteredLockers/Mutex"}
+		assert.Nil(t, find(m.Name))
+		var wg sync.WaitGroup
+		m.LockDo(func() {
+			m.LockDo(func() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					m.LockDo(func() {})
+				}()
+				for len(find(m.Name).Waiters) == 0 {
+					time.Sleep(time.Millisecond)
+				}
+
+				info := find(m.Name)
+				assert.Equal(t, "Mutex", info.Type)
+				assert.Equal(t, GetGoroutineID(), info.Owner)
+				assert.Equal(t, 2, info.Depth)
+				assert.NotEmpty(t, info.OwnerStack)
+				if assert.Len(t, info.Waiters, 1) {
+					assert.True(t, info.Waiters[0].IsWrite)
+					assert.False(t, info.Waiters[0].Since.IsZero())
+				}
+			})
+		})
+		wg.Wait()
+		info := find(m.Name)
+		assert.Equal(t, GoroutineID(0), info.Owner)
+		assert.Empty(t, info.Waiters)
+	})
+	t.Run("RWMutex", func(t *testing.T) {
+		m := &RWMutex{Name: "TestRegisteredLockers/RWMutex"}
+		m.RLockDo(func() {
+			m.RLockDo(func() {
+				info := find(m.Name)
+				assert.Equal(t, "RWMutex", info.Type)
+				assert.Equal(t, GoroutineID(0), info.Owner)
+				if assert.Len(t, info.Readers, 1) {
+					assert.Equal(t, GetGoroutineID(), info.Readers[0].GoroutineID)
+					assert.Equal(t, int64(2), info.Readers[0].Count)
+				}
+			})
+		})
+	})
+	t.Run("weak", func(t *testing.T) {
+		func() {
+			m := &Mutex{Name: "TestRegisteredLockers/weak"}
+			m.LockDo(func() {})
+			assert.NotNil(t, find(m.Name))
+		}()
+		runtime.GC()
+		assert.Nil(t, find("TestRegisteredLockers/weak"))
+	})
+	t.Run("unnamed", func(t *testing.T) {
+		m := &Mutex{}
+		m.LockDo(func() {})
+		for _, info := range RegisteredLockers() {
+			assert.NotSame(t, m, info.Locker)
+		}
+	})
+}
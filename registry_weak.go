@@ -0,0 +1,83 @@
+//go:build go1.24
+// +build go1.24
+
+package gorex
+
+import (
+	"sync"
+	"weak"
+)
+
+// weakLockers is a list of weak pointers to registered mutexes.
+type weakLockers[T any] struct {
+	pointers []weak.Pointer[T]
+
+	// pruneAt is the length of pointers at which the pointers to
+	// the collected mutexes are removed.
+	pruneAt int
+}
+
+func (l *weakLockers[T]) add(m *T) {
+	if len(l.pointers) >= l.pruneAt {
+		l.prune()
+		l.pruneAt = 2*len(l.pointers) + 16
+	}
+	l.pointers = append(l.pointers, weak.Make(m))
+}
+
+// prune removes the pointers to the collected mutexes.
+func (l *weakLockers[T]) prune() {
+	alive := l.pointers[:0]
+	for _, p := range l.pointers {
+		if p.Value() != nil {
+			alive = append(alive, p)
+		}
+	}
+	for idx := len(alive); idx < len(l.pointers); idx++ {
+		l.pointers[idx] = weak.Pointer[T]{}
+	}
+	l.pointers = alive
+}
+
+// alive returns the mutexes which are not collected, yet.
+func (l *weakLockers[T]) alive() []*T {
+	result := make([]*T, 0, len(l.pointers))
+	for _, p := range l.pointers {
+		if m := p.Value(); m != nil {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+var globalRegistry struct {
+	locker    sync.Mutex
+	mutexes   weakLockers[Mutex]
+	rwMutexes weakLockers[RWMutex]
+}
+
+func registerMutex(m *Mutex) {
+	globalRegistry.locker.Lock()
+	defer globalRegistry.locker.Unlock()
+	globalRegistry.mutexes.add(m)
+}
+
+func registerRWMutex(m *RWMutex) {
+	globalRegistry.locker.Lock()
+	defer globalRegistry.locker.Unlock()
+	globalRegistry.rwMutexes.add(m)
+}
+
+func registeredLockers() []registeredLocker {
+	globalRegistry.locker.Lock()
+	defer globalRegistry.locker.Unlock()
+
+	var result []registeredLocker
+	for _, m := range globalRegistry.mutexes.alive() {
+		result = append(result, m)
+	}
+	for _, m := range globalRegistry.rwMutexes.alive() {
+		result = append(result, m)
+	}
+	return result
+}
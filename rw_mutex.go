@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/xaionaro-go/spinlock"
 )
@@ -12,6 +13,14 @@ import (
 // the same way as sync.RWMutex, but tracks which goroutine locked
 // it. So it could be locked multiple times with the same routine.
 type RWMutex struct {
+	// Name is a human-readable name of the mutex. A mutex with a non-empty
+	// Name is registered (on the first lock) in the global registry of
+	// mutexes (see RegisteredLockers), so its state could be inspected
+	// while debugging (see package "debughttp").
+	//
+	// It should not be changed after the first lock.
+	Name string
+
 	// InfiniteContext is used as the default context used on any try to lock if
 	// a custom context is not set (see LockCtx/RLockCtx), but with the difference
 	// if this context will be done, then it will panic with debugging information.
@@ -21,30 +30,86 @@ type RWMutex struct {
 	// The zero-value means to use DefaultInfiniteContext.
 	InfiniteContext context.Context
 
+	// OnDeadlock is called when InfiniteContext is done. If the handler
+	// returns, then the goroutine continues to wait for the lock.
+	//
+	// The zero-value means to use the package-level OnDeadlock.
+	OnDeadlock func(*DeadlockReport)
+
+	// HoldWarnThreshold is the maximal duration the lock could be held
+	// by a goroutine before reporting it via OnLongHold.
+	//
+	// The zero-value means to use DefaultHoldWarnThreshold, a negative
+	// value disables the watching.
+	HoldWarnThreshold time.Duration
+
+	// OnLongHold is called when the lock is held longer than
+	// HoldWarnThreshold.
+	//
+	// The zero-value means to use the package-level OnLongHold.
+	OnLongHold func(*LongHoldReport)
+
+	// Fair enables the FIFO mode: the lock is granted to the waiting
+	// goroutines in the order of waiting (consecutive readers are granted
+	// together), otherwise they are only woken up and race with others.
+	// A new reader waits if somebody is already queued
+	// (for example, a writer), so writers are not starved by a continuous flow
+	// of readers. It is slower, but it prevents starvation.
+	//
+	// Goroutines which already hold a read lock are not queued behind other
+	// goroutines on RLock (and are queued first on Lock/Upgrade), otherwise
+	// they would deadlock with a queued writer.
+	//
+	// It should not be changed while the mutex is in use.
+	Fair bool
+
+	// Metrics receives the statistics of locking of the mutex (acquisitions,
+	// wait and hold durations, etc; separately for read and write locks).
+	//
+	// The zero-value means to use DefaultMetrics.
+	Metrics Metrics
+
 	lazyInitOnce sync.Once
 
-	rlockDone      chan struct{}
-	lockDone       chan struct{}
-	lockCount      int
-	lockedBy       GoroutineID
-	rlockCount     int64
-	backendLocker  sync.Mutex
-	internalLocker spinlock.Locker
-	usedBy         map[GoroutineID]*int64
-	int64Pool      int64Pool
-	gcCallCount    uint8
+	lockCount        int
+	lockedBy         GoroutineID
+	rlockCount       int64
+	upgradeableBy    GoroutineID
+	upgradeableCount int
+	upgradeDepth     int
+	backendLocker    sync.Mutex
+	internalLocker   spinlock.Locker
+	lockedByStack    []uintptr
+	lockedByAt       time.Time
+	lockedByWatchdog holdWatchdog
+	usedBy           map[GoroutineID]*int64
+	usedByInfo       map[GoroutineID]*rwMutexReader
+	int64Pool        int64Pool
+	gcCallCount      uint8
+	waiters          lockWaiterQueue
+}
+
+// rwMutexReader is the information about a goroutine holding a read lock.
+type rwMutexReader struct {
+	stack        []uintptr
+	since        time.Time
+	holdWatchdog holdWatchdog
 }
 
 func (m *RWMutex) lazyInit() {
 	m.lazyInitOnce.Do(func() {
 		m.usedBy = map[GoroutineID]*int64{}
+		m.usedByInfo = map[GoroutineID]*rwMutexReader{}
+		if m.Name != "" {
+			registerRWMutex(m)
+		}
 	})
 }
 
 // Lock is analog of `(*sync.RWMutex)`.Lock, but it allows one goroutine
 // to call it and RLock multiple times without calling Unlock/RUnlock.
 func (m *RWMutex) Lock() {
-	if !m.lock(nil, true) {
+	if !m.lock(nil, GetGoroutineID(), true) {
 		panic("should not happen")
 	}
 }
@@ -54,14 +119,18 @@ func (m *RWMutex) Lock() {
 //
 // Returns `false` if was unable to lock.
 func (m *RWMutex) LockTry() bool {
-	return m.lock(nil, false)
+	return m.lock(nil, GetGoroutineID(), false)
 }
 
 // LockCtx is analog of Lock(), but allows to continue the try to lock only until context is done.
 //
+// If the context carries a lock owner (see WithOwner), then the lock is
+// acquired by this owner instead of the calling goroutine (and it should be
+// released by UnlockCtx with a context carrying the same owner).
+//
 // Returns `false` if was unable to lock (context finished before it was possible to lock).
 func (m *RWMutex) LockCtx(ctx context.Context) bool {
-	return m.lock(ctx, true)
+	return m.lock(ctx, lockOwnerID(ctx), true)
 }
 
 func (m *RWMutex) infiniteContext() context.Context {
@@ -71,24 +140,50 @@ func (m *RWMutex) infiniteContext() context.Context {
 	return m.InfiniteContext
 }
 
-func (m *RWMutex) lock(ctx context.Context, shouldWait bool) bool {
+func (m *RWMutex) metrics() Metrics {
+	if m.Metrics == nil {
+		return DefaultMetrics
+	}
+	return m.Metrics
+}
+
+func (m *RWMutex) lock(ctx context.Context, me GoroutineID, shouldWait bool) bool {
 	m.lazyInit()
-	me := GetGoroutineID()
+	if shouldWait {
+		lockOrderBeforeLock(m, me, true)
+	}
+	metrics := m.metrics()
 
 	m.internalLocker.Lock()
 	if m.lockedBy == me {
 		// already locked by me
 		m.lockCount++
 		m.internalLocker.Unlock()
+		lockOrderLocked(m, me, true)
+		metricsReacquired(metrics, m, true)
 		return true
 	}
 
-	if !m.setLockedByMe(ctx, me, shouldWait) {
+	var waitStartedAt time.Time
+	if !m.setLockedByMe(ctx, me, shouldWait, &waitStartedAt) {
+		contentionRecord(waitStartedAt)
+		if shouldWait {
+			metricsTimedOut(metrics, m, true, waitStartedAt)
+		} else {
+			metricsTryLockFailed(metrics, m, true)
+		}
 		return false
 	}
-	goroutineOpenedLock(m, true)
+	m.lockedByStack = acquisitionStack(m.lockedByStack, 2)
+	m.lockedByAt = holdStartTime(metrics)
+	m.lockedByWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, true, m.lockedByStack)
+	goroutineOpenedLock(m, me, true)
 	m.internalLocker.Unlock()
 	m.backendLocker.Lock()
+	lockOrderLocked(m, me, true)
+	contentionRecord(waitStartedAt)
+	metricsAcquired(metrics, m, true, waitStartedAt)
+	traceHoldStart(m.Name)
 	return true
 }
 
@@ -96,61 +191,87 @@ func (m *RWMutex) setLockedByMe(
 	ctx context.Context,
 	me GoroutineID,
 	shouldWait bool,
-) (result bool) {
-	defer func() {
-		if !result {
-			return
-		}
-		m.lockCount++
-		m.lockedBy = me
-	}()
+	waitStartedAt *time.Time,
+) bool {
+	isInfiniteContext := false
+	if ctx == nil {
+		ctx = m.infiniteContext()
+		isInfiniteContext = true
+	}
+	var w *lockWaiter
 	for {
-		if m.lockCount == 0 {
-			if m.rlockCount == 0 {
-				return true
-			}
-			if myReadersCountPtr, _ := m.usedBy[me]; myReadersCountPtr != nil {
-				if m.rlockCount-*myReadersCountPtr == 0 {
-					return true
-				}
-			}
+		if m.canLock(me) {
+			m.lockCount++
+			m.lockedBy = me
+			return true
 		}
 		if !shouldWait {
 			m.internalLocker.Unlock()
 			return false
 		}
-		if m.rlockDone == nil {
-			m.rlockDone = make(chan struct{})
-		}
-		rlockDone := m.rlockDone
-		if m.lockDone == nil {
-			m.lockDone = make(chan struct{})
-		}
-		lockDone := m.lockDone
-		isInfiniteContext := false
-		if ctx == nil {
-			ctx = m.infiniteContext()
-			isInfiniteContext = true
-		}
-		m.internalLocker.Unlock()
-		select {
-		case <-rlockDone:
-		case <-lockDone:
-		case <-ctx.Done():
-			if isInfiniteContext {
-				m.debugPanic()
-			}
+		w = m.waiters.push(me, lockWaiterKindWrite, m.readersCountOf(me) != 0, w)
+		if !m.wait(&ctx, w, isInfiniteContext, waitStartedAt) {
 			return false
 		}
-		m.internalLocker.Lock()
+		if w.isGranted {
+			// the write lock is already set by grantWaiters
+			return true
+		}
+	}
+}
+
+// canLock returns true if the write lock could be acquired by "me" right away.
+func (m *RWMutex) canLock(me GoroutineID) bool {
+	if m.lockCount != 0 {
+		return false
+	}
+	myReadersCount := m.readersCountOf(me)
+	if m.rlockCount-myReadersCount != 0 {
+		return false
+	}
+	if m.Fair && myReadersCount == 0 && !m.waiters.isEmpty() {
+		// do not overtake the queue
+		return false
+	}
+	return true
+}
+
+// canRLock returns true if a read lock could be acquired by "me" right away.
+func (m *RWMutex) canRLock(me GoroutineID) bool {
+	if m.lockCount != 0 {
+		return m.lockedBy == me
 	}
+	if m.Fair && !m.waiters.isEmpty() && m.readersCountOf(me) == 0 {
+		// do not overtake the queue (a writer could be waiting there)
+		return false
+	}
+	return true
+}
+
+// readersCountOf returns the amount of read locks held by "me".
+func (m *RWMutex) readersCountOf(me GoroutineID) int64 {
+	v := m.usedBy[me]
+	if v == nil {
+		return 0
+	}
+	return *v
 }
 
 // Unlock is analog of `(*sync.RWMutex)`.Unlock, but it cannot be called
 // from a routine which does not hold the lock (see `Lock`).
 func (m *RWMutex) Unlock() {
-	me := GetGoroutineID()
+	m.unlock(GetGoroutineID())
+}
 
+// UnlockCtx is analog of Unlock(), but for the lock acquired by LockCtx
+// with a context carrying a lock owner (see WithOwner).
+//
+// If the context does not carry a lock owner, then it is the same as Unlock().
+func (m *RWMutex) UnlockCtx(ctx context.Context) {
+	m.unlock(lockOwnerID(ctx))
+}
+
+func (m *RWMutex) unlock(me GoroutineID) {
 	m.internalLocker.Lock()
 	switch {
 	case m.lockedBy == 0:
@@ -161,24 +282,83 @@ func (m *RWMutex) Unlock() {
 		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.lockedBy))
 	}
 
+	m.decLockCount(me)
+	lockOrderUnlocked(m, me, true)
+}
+
+// decLockCount releases one level of the write lock. It should be called with
+// locked internalLocker, and it unlocks internalLocker.
+func (m *RWMutex) decLockCount(me GoroutineID) {
+	var heldSince time.Time
 	m.lockCount--
 	if m.lockCount == 0 {
 		m.lockedBy = 0
-		goroutineClosedLock(m, true)
+		m.upgradeDepth = 0
+		heldSince = m.lockedByAt
+		m.lockedByAt = time.Time{}
+		m.lockedByWatchdog.stop()
+		goroutineClosedLock(m, me, true)
 		m.backendLocker.Unlock()
+		m.wakeUpWaiters()
 	}
-
-	chPtr := m.lockDone
-	m.lockDone = nil
 	m.internalLocker.Unlock()
-	if chPtr != nil {
-		close(chPtr)
+	metricsReleased(m.metrics(), m, true, heldSince)
+	traceHoldEnd(m.Name, heldSince)
+}
+
+// Downgrade atomically converts the write lock of the calling goroutine into
+// a read lock. There is no window where another writer could acquire the lock.
+//
+// If the write lock was acquired by Upgrade, then it is converted back to
+// the upgradeable read lock (see UpgradeableRLock). Otherwise it is converted
+// to a read lock which should be released by RUnlock.
+//
+// If the write lock was acquired multiple times, then only one level is
+// converted.
+func (m *RWMutex) Downgrade() {
+	m.downgrade(GetGoroutineID())
+}
+
+// DowngradeCtx is analog of Downgrade(), but for the lock acquired with
+// a context carrying a lock owner (see WithOwner).
+func (m *RWMutex) DowngradeCtx(ctx context.Context) {
+	m.downgrade(lockOwnerID(ctx))
+}
+
+func (m *RWMutex) downgrade(me GoroutineID) {
+	m.internalLocker.Lock()
+	switch {
+	case m.lockedBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to downgrade a non-locked mutex.")
+	case me != m.lockedBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who locked this mutex: %X != %X", me, m.lockedBy))
+	}
+
+	if m.upgradeDepth > 0 {
+		// The goroutine still holds the upgradeable read lock.
+		m.upgradeDepth--
+		m.decLockCount(me)
+		lockOrderUnlocked(m, me, true)
+		return
+	}
+
+	isFirstRead := m.incMyReaders(me, 2)
+	m.decLockCount(me)
+	lockOrderDowngraded(m, me)
+	if isFirstRead {
+		metricsAcquired(m.metrics(), m, false, time.Time{})
+		traceHoldStart(m.Name)
 	}
 }
 
 // LockDo is a wrapper around Lock and Unlock.
 // It's a handy function to see in the call stack trace which locker where was locked.
 // Also it's handy not to forget to unlock the locker.
+//
+// If fn panics, then the lock is released anyway (only this level of it,
+// if the mutex was locked multiple times), so the mutex stays consistent.
 func (m *RWMutex) LockDo(fn func()) {
 	m.Lock()
 	defer m.Unlock()
@@ -188,6 +368,8 @@ func (m *RWMutex) LockDo(fn func()) {
 
 // LockTryDo is a wrapper around LockTry and Unlock.
 //
+// If fn panics, then the lock is released anyway (as by LockDo).
+//
 // See also LockDo and LockTry.
 func (m *RWMutex) LockTryDo(fn func()) (success bool) {
 	if !m.LockTry() {
@@ -200,28 +382,67 @@ func (m *RWMutex) LockTryDo(fn func()) (success bool) {
 	return
 }
 
-// LockCtxDo is a wrapper around LockCtx and Unlock.
+// LockCtxDo is a wrapper around LockCtx and UnlockCtx.
+//
+// If fn panics, then the lock is released anyway (as by LockDo).
 //
 // See also LockDo and LockCtx.
 func (m *RWMutex) LockCtxDo(ctx context.Context, fn func()) (success bool) {
 	if !m.LockCtx(ctx) {
 		return false
 	}
-	defer m.Unlock()
+	defer m.UnlockCtx(ctx)
 
 	success = true
 	fn()
 	return
 }
 
-func (m *RWMutex) incMyReaders(me GoroutineID) {
-	if v := m.usedBy[me]; v == nil {
+// incMyReaders increments the amount of read locks held by goroutine "me".
+// Returns true if it is the first read lock of goroutine "me".
+//
+// "skip" is the number of stack frames to skip in the recorded acquisition
+// stack, where 0 identifies the caller of incMyReaders.
+func (m *RWMutex) incMyReaders(me GoroutineID, skip int) bool {
+	if !m.incMyReadersCount(me) {
+		return false
+	}
+	m.startReading(me, skip+1)
+	return true
+}
+
+// incMyReadersCount increments the counters of read locks. Returns true
+// if it is the first read lock of goroutine "me".
+func (m *RWMutex) incMyReadersCount(me GoroutineID) bool {
+	m.rlockCount++
+	v := m.usedBy[me]
+	switch {
+	case v == nil:
 		m.usedBy[me] = m.int64Pool.get()
-		goroutineOpenedLock(m, false)
-	} else {
+	case *v == 0:
+		*v++
+	default:
 		*v++
+		return false
 	}
-	m.rlockCount++
+	return true
+}
+
+// startReading records the first read lock of goroutine "me" (see
+// incMyReadersCount).
+//
+// "skip" is the number of stack frames to skip in the recorded acquisition
+// stack, where 0 identifies the caller of startReading.
+func (m *RWMutex) startReading(me GoroutineID, skip int) {
+	goroutineOpenedLock(m, me, false)
+	info := m.usedByInfo[me]
+	if info == nil {
+		info = &rwMutexReader{}
+		m.usedByInfo[me] = info
+	}
+	info.stack = acquisitionStack(info.stack, skip+1)
+	info.since = holdStartTime(m.metrics())
+	info.holdWatchdog.start(m, m.HoldWarnThreshold, m.OnLongHold, me, false, info.stack)
 }
 
 func (m *RWMutex) gc() {
@@ -235,34 +456,42 @@ func (m *RWMutex) gc() {
 			continue
 		}
 		delete(m.usedBy, k)
+		delete(m.usedByInfo, k)
 		m.int64Pool.put(v)
 	}
 }
 
-func (m *RWMutex) decMyReaders(me GoroutineID) {
-	m.rlockCount--
+// decMyReaders decrements the amount of read locks held by goroutine "me".
+// It should be called with locked internalLocker.
+//
+// Returns the moment the goroutine acquired the first read lock (see
+// holdStartTime) if it was the last read lock of the goroutine.
+func (m *RWMutex) decMyReaders(me GoroutineID) (heldSince time.Time) {
 	v := m.usedBy[me]
 	if v == nil || *v == 0 {
+		m.internalLocker.Unlock()
 		panic("RUnlock()-ing not RLock()-ed")
 	}
+	m.rlockCount--
 	*v--
 	if *v != 0 {
 		return
 	}
-	goroutineClosedLock(m, false)
-	m.gc()
-	ch := m.rlockDone
-	if ch == nil {
-		return
+	if info := m.usedByInfo[me]; info != nil {
+		info.holdWatchdog.stop()
+		heldSince = info.since
+		info.since = time.Time{}
 	}
-	close(ch)
-	m.rlockDone = nil
+	goroutineClosedLock(m, me, false)
+	m.gc()
+	m.wakeUpWaiters()
+	return
 }
 
 // RLock is analog of `(*sync.RWMutex)`.RLock, but it allows one goroutine
 // to call Lock and RLock multiple times without calling Unlock/RUnlock.
 func (m *RWMutex) RLock() {
-	m.rLock(nil, true)
+	m.rLock(nil, GetGoroutineID(), true)
 }
 
 // RLockTry is analog of RLock(), but it does not block if it cannot lock
@@ -270,78 +499,108 @@ func (m *RWMutex) RLock() {
 //
 // Returns `false` if was unable to lock.
 func (m *RWMutex) RLockTry() bool {
-	return m.rLock(nil, false)
+	return m.rLock(nil, GetGoroutineID(), false)
 }
 
 // RLockCtx is analog of RLock(), but allows to continue the try to lock only until context is done.
 //
+// If the context carries a lock owner (see WithOwner), then the lock is
+// acquired by this owner instead of the calling goroutine (and it should be
+// released by RUnlockCtx with a context carrying the same owner).
+//
 // Returns `false` if was unable to lock.
 func (m *RWMutex) RLockCtx(ctx context.Context) bool {
-	return m.rLock(ctx, true)
+	return m.rLock(ctx, lockOwnerID(ctx), true)
 }
 
 func (m *RWMutex) rLock(
 	ctx context.Context,
+	me GoroutineID,
 	shouldWait bool,
 ) bool {
 	m.lazyInit()
-	me := GetGoroutineID()
+	if shouldWait {
+		lockOrderBeforeLock(m, me, false)
+	}
+	metrics := m.metrics()
 
-	m.internalLocker.Lock()
-	for {
-		if m.lockCount == 0 {
-			break
-		}
-		monopolizedBy := m.lockedBy
-		if monopolizedBy == me {
-			break
-		}
+	isInfiniteContext := false
+	if ctx == nil {
+		ctx = m.infiniteContext()
+		isInfiniteContext = true
+	}
 
+	var waitStartedAt time.Time
+	var w *lockWaiter
+	m.internalLocker.Lock()
+	for !m.canRLock(me) {
 		if !shouldWait {
 			m.internalLocker.Unlock()
+			metricsTryLockFailed(metrics, m, false)
 			return false
 		}
-
-		if m.lockDone == nil {
-			m.lockDone = make(chan struct{})
-		}
-		ch := m.lockDone
-
-		isInfiniteContext := false
-		if ctx == nil {
-			ctx = m.infiniteContext()
-			isInfiniteContext = true
-		}
-		m.internalLocker.Unlock()
-		select {
-		case <-ch:
-		case <-ctx.Done():
-			if isInfiniteContext {
-				m.debugPanic()
-			}
+		w = m.waiters.push(me, lockWaiterKindRead, false, w)
+		if !m.wait(&ctx, w, isInfiniteContext, &waitStartedAt) {
+			contentionRecord(waitStartedAt)
+			metricsTimedOut(metrics, m, false, waitStartedAt)
 			return false
 		}
-		m.internalLocker.Lock()
+		if w.isGranted {
+			break
+		}
 	}
 
-	m.incMyReaders(me)
+	var isFirstRead bool
+	if w != nil && w.isGranted {
+		// the read lock is already counted by grantWaiters
+		isFirstRead = w.isFirstRead
+		if isFirstRead {
+			m.startReading(me, 2)
+		}
+	} else {
+		isFirstRead = m.incMyReaders(me, 2)
+	}
 	m.internalLocker.Unlock()
+	lockOrderLocked(m, me, false)
+	contentionRecord(waitStartedAt)
+	if isFirstRead {
+		metricsAcquired(metrics, m, false, waitStartedAt)
+		traceHoldStart(m.Name)
+	} else {
+		metricsReacquired(metrics, m, false)
+	}
 	return true
 }
 
 // RUnlock is analog of `(*sync.RWMutex)`.RUnlock, but it cannot be called
 // from a routine which does not hold the lock (see `RLock`).
 func (m *RWMutex) RUnlock() {
-	me := GetGoroutineID()
+	m.rUnlock(GetGoroutineID())
+}
 
+// RUnlockCtx is analog of RUnlock(), but for the lock acquired by RLockCtx
+// with a context carrying a lock owner (see WithOwner).
+//
+// If the context does not carry a lock owner, then it is the same as RUnlock().
+func (m *RWMutex) RUnlockCtx(ctx context.Context) {
+	m.rUnlock(lockOwnerID(ctx))
+}
+
+func (m *RWMutex) rUnlock(me GoroutineID) {
 	m.internalLocker.Lock()
-	m.decMyReaders(me)
+	heldSince := m.decMyReaders(me)
 	m.internalLocker.Unlock()
+	lockOrderUnlocked(m, me, false)
+	metricsReleased(m.metrics(), m, false, heldSince)
+	traceHoldEnd(m.Name, heldSince)
 }
 
 // RLockDo is a wrapper around RLock and RUnlock.
 // It's a handy function to see in the call stack trace which locker where was locked.
 // Also it's handy not to forget to unlock the locker.
+//
+// If fn panics, then the lock is released anyway (only this level of it,
+// if the mutex was locked multiple times), so the mutex stays consistent.
 func (m *RWMutex) RLockDo(fn func()) {
 	m.RLock()
 	defer m.RUnlock()
@@ -351,6 +610,8 @@ func (m *RWMutex) RLockDo(fn func()) {
 
 // RLockTryDo is a wrapper around RLockTry and RUnlock.
 //
+// If fn panics, then the lock is released anyway (as by RLockDo).
+//
 // See also RLockDo and RLockTry.
 func (m *RWMutex) RLockTryDo(fn func()) (success bool) {
 	if !m.RLockTry() {
@@ -363,22 +624,108 @@ func (m *RWMutex) RLockTryDo(fn func()) (success bool) {
 	return
 }
 
-// RLockCtxDo is a wrapper around RLockTry and RUnlock.
+// RLockCtxDo is a wrapper around RLockCtx and RUnlockCtx.
+//
+// If fn panics, then the lock is released anyway (as by RLockDo).
 //
 // See also RLockDo and RLockCtx.
 func (m *RWMutex) RLockCtxDo(ctx context.Context, fn func()) (success bool) {
 	if !m.RLockCtx(ctx) {
 		return false
 	}
-	defer m.RUnlock()
+	defer m.RUnlockCtx(ctx)
 
 	success = true
 	fn()
 	return
 }
 
-func (m *RWMutex) debugPanic() {
+// wait waits until waiter "w" (which is already queued) is woken up. It should
+// be called with locked internalLocker.
+//
+// Returns `true` (with locked internalLocker) if the waiter was woken up, or
+// `false` (with unlocked internalLocker) if context finished before that.
+func (m *RWMutex) wait(
+	ctx *context.Context,
+	w *lockWaiter,
+	isInfiniteContext bool,
+	waitStartedAt *time.Time,
+) bool {
+	m.internalLocker.Unlock()
+	metricsWaitStart(m.metrics(), waitStartedAt)
+	contentionWaitStart(waitStartedAt)
+	waitRegion := traceWaitStart(*ctx, m.Name)
+	waitNode := waitForGraphWaiting(m, w, *ctx, isInfiniteContext)
+	isWoken := w.wait(ctx, isInfiniteContext, m)
+	waitForGraphWoken(w.me, waitNode)
+	traceRegionEnd(waitRegion)
+	m.internalLocker.Lock()
+	if isWoken || w.isGranted {
+		return true
+	}
+	if !w.isWoken {
+		m.waiters.remove(w)
+	}
+	// the wake-up should not be lost (and the removal of the waiter could
+	// unblock the waiters behind it in the fair mode)
+	m.wakeUpWaiters()
+	m.internalLocker.Unlock()
+	return false
+}
+
+// wakeUpWaiters wakes up the waiters which could acquire the lock now: either
+// one writer, or all the readers (but only one of them for the upgradeable
+// slot). In the fair mode (see Fair) the lock is granted to them instead (see
+// grantWaiters). It should be called with locked internalLocker.
+func (m *RWMutex) wakeUpWaiters() {
+	if m.lockCount != 0 || m.waiters.isEmpty() {
+		return
+	}
+	if m.Fair {
+		m.grantWaiters()
+		return
+	}
+
+	isReaderWoken := false
+	isUpgradeableSlotTaken := m.upgradeableBy != 0
+	for idx := 0; idx < len(m.waiters.waiters); {
+		w := m.waiters.waiters[idx]
+		switch w.kind {
+		case lockWaiterKindWrite:
+			if isReaderWoken || m.rlockCount-m.readersCountOf(w.me) != 0 {
+				idx++
+				continue
+			}
+			m.waiters.removeAt(idx)
+			w.wakeUp()
+			return
+		case lockWaiterKindUpgradeable:
+			if isUpgradeableSlotTaken && m.upgradeableBy != w.me {
+				idx++
+				continue
+			}
+			isUpgradeableSlotTaken = true
+		}
+		m.waiters.removeAt(idx)
+		w.wakeUp()
+		isReaderWoken = true
+	}
+}
+
+// Waiters returns the goroutines which currently wait for the lock
+// (in the order of waiting, but priority waiters go first, see Fair).
+func (m *RWMutex) Waiters() []DeadlockWaiter {
 	m.internalLocker.Lock()
 	defer m.internalLocker.Unlock()
-	debugPanic(m.lockedBy, m.usedBy)
+	return m.waiters.deadlockWaiters()
+}
+
+func (m *RWMutex) debugPanic() {
+	m.internalLocker.Lock()
+	report := newDeadlockReport(m, m.lockedBy, m.lockedByStack, m.usedBy, m.usedByInfo)
+	report.Waiters = m.waiters.deadlockWaiters()
+	onDeadlock := m.OnDeadlock
+	m.internalLocker.Unlock()
+
+	debugPanic(report, onDeadlock)
 }
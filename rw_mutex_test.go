@@ -3,8 +3,10 @@ package gorex
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -103,6 +105,61 @@ func benchmarkRLockedParallelRLockRUnlock(b *testing.B, locker rwLocker) {
 	b.StopTimer()
 }
 
+// benchmarkContended runs b.N iterations of fn split between "goroutines"
+// goroutines, which start simultaneously. It reports the amount of wake-ups
+// of waiting goroutines per iteration.
+func benchmarkContended(b *testing.B, goroutines int, fn func(goroutineIdx int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for goroutineIdx := 0; goroutineIdx < goroutines; goroutineIdx++ {
+		iterations := b.N / goroutines
+		if goroutineIdx < b.N%goroutines {
+			iterations++
+		}
+		wg.Add(1)
+		go func(goroutineIdx, iterations int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < iterations; i++ {
+				fn(goroutineIdx)
+			}
+		}(goroutineIdx, iterations)
+	}
+
+	wakeUpsBefore := atomic.LoadUint64(&wakeUpCount)
+	b.ReportAllocs()
+	b.ResetTimer()
+	close(start)
+	wg.Wait()
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadUint64(&wakeUpCount)-wakeUpsBefore)/float64(b.N), "wakeups/op")
+}
+
+func benchmarkContendedLockUnlock(b *testing.B, locker locker, goroutines int) {
+	benchmarkContended(b, goroutines, func(int) {
+		locker.Lock()
+		// let the other goroutines to run into the locked mutex
+		runtime.Gosched()
+		locker.Unlock()
+	})
+}
+
+// benchmarkContendedMixed is analog of benchmarkContendedLockUnlock, but
+// every 8th goroutine is a writer and the rest are readers.
+func benchmarkContendedMixed(b *testing.B, locker rwLocker, goroutines int) {
+	benchmarkContended(b, goroutines, func(goroutineIdx int) {
+		if goroutineIdx%8 == 0 {
+			locker.Lock()
+			runtime.Gosched()
+			locker.Unlock()
+			return
+		}
+		locker.RLock()
+		runtime.Gosched()
+		locker.RUnlock()
+	})
+}
+
 func Benchmark(b *testing.B) {
 	b.Run("Lock-Unlock", func(b *testing.B) {
 		b.Run("single", func(b *testing.B) {
@@ -134,6 +191,36 @@ func Benchmark(b *testing.B) {
 			})
 		})
 	})
+	for _, goroutines := range []int{64, 256} {
+		b.Run(fmt.Sprintf("Lock-Unlock/contended-%d", goroutines), func(b *testing.B) {
+			b.Run("sync.Mutex", func(b *testing.B) {
+				benchmarkContendedLockUnlock(b, &sync.Mutex{}, goroutines)
+			})
+			b.Run("Mutex", func(b *testing.B) {
+				benchmarkContendedLockUnlock(b, &Mutex{}, goroutines)
+			})
+			b.Run("Mutex-Fair", func(b *testing.B) {
+				benchmarkContendedLockUnlock(b, &Mutex{Fair: true}, goroutines)
+			})
+			b.Run("RWMutex", func(b *testing.B) {
+				benchmarkContendedLockUnlock(b, &RWMutex{}, goroutines)
+			})
+			b.Run("RWMutex-Fair", func(b *testing.B) {
+				benchmarkContendedLockUnlock(b, &RWMutex{Fair: true}, goroutines)
+			})
+		})
+		b.Run(fmt.Sprintf("Lock-Unlock+RLock-RUnlock/contended-%d", goroutines), func(b *testing.B) {
+			b.Run("sync.RWMutex", func(b *testing.B) {
+				benchmarkContendedMixed(b, &sync.RWMutex{}, goroutines)
+			})
+			b.Run("RWMutex", func(b *testing.B) {
+				benchmarkContendedMixed(b, &RWMutex{}, goroutines)
+			})
+			b.Run("RWMutex-Fair", func(b *testing.B) {
+				benchmarkContendedMixed(b, &RWMutex{Fair: true}, goroutines)
+			})
+		})
+	}
 	b.Run("RLock-RUnlock", func(b *testing.B) {
 		b.Run("single", func(b *testing.B) {
 			b.Run("sync.RWMutex", func(b *testing.B) {
@@ -295,6 +382,55 @@ func TestRWMutex(t *testing.T) {
 
 				assert.NotNil(t, result, result)
 			})
+			t.Run("OnDeadlock", func(t *testing.T) {
+				var reports []*DeadlockReport
+				oldOnDeadlock := OnDeadlock
+				OnDeadlock = func(report *DeadlockReport) {
+					reports = append(reports, report)
+				}
+				defer func() { OnDeadlock = oldOnDeadlock }()
+
+				var cancelFn context.CancelFunc
+				locker := &RWMutex{}
+				locker.InfiniteContext, cancelFn = context.WithDeadline(context.Background(), time.Now())
+				defer cancelFn()
+
+				var wg0, wg1 sync.WaitGroup
+				wg0.Add(1)
+				wg1.Add(1)
+				var reader GoroutineID
+				go locker.RLockDo(func() {
+					reader = GetGoroutineID()
+					locker.RLockDo(func() {
+						wg1.Done()
+						wg0.Wait()
+					})
+				})
+				wg1.Wait()
+				time.AfterFunc(10*time.Millisecond, wg0.Done)
+				locker.LockDo(func() {})
+
+				if !assert.Len(t, reports, 1) {
+					return
+				}
+				report := reports[0]
+				assert.Zero(t, report.Owner)
+				if assert.Len(t, report.Readers, 1) {
+					assert.Equal(t, reader, report.Readers[0].GoroutineID)
+					assert.Equal(t, int64(2), report.Readers[0].Count)
+					if assert.NotEmpty(t, report.Readers[0].Stack) {
+						assert.Equal(t, "github.com/xaionaro-go/gorex.(*RWMutex).RLockDo", report.Readers[0].Stack[0].Function)
+					}
+				}
+				if assert.Len(t, report.Waiters, 1) {
+					waiter := report.Waiters[0]
+					assert.Equal(t, GetGoroutineID(), waiter.GoroutineID)
+					assert.True(t, waiter.IsWrite)
+					assert.False(t, waiter.Since.IsZero())
+					assert.NotEmpty(t, waiter.Stack)
+				}
+				assert.Regexp(t, `Goroutine \d+ is waiting for a write lock for \S+ at \S+/rw_mutex_test.go:\d+\.`, report.String())
+			})
 		})
 	})
 	t.Run("RLockDo", func(t *testing.T) {
@@ -412,6 +548,51 @@ func TestRWMutex(t *testing.T) {
 			})
 		})
 	})
+	t.Run("Downgrade", func(t *testing.T) {
+		t.Run("positive", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.Lock()
+			locker.Lock()
+			locker.Downgrade()
+			locker.Downgrade()
+
+			var writerLocked uint32
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.True(t, locker.RLockTry())
+				locker.RUnlock()
+				assert.False(t, locker.LockTry())
+				locker.Lock()
+				atomic.StoreUint32(&writerLocked, 1)
+				locker.Unlock()
+			}()
+
+			time.Sleep(time.Millisecond)
+			assert.Equal(t, uint32(0), atomic.LoadUint32(&writerLocked))
+			locker.RUnlock()
+			time.Sleep(time.Millisecond)
+			assert.Equal(t, uint32(0), atomic.LoadUint32(&writerLocked))
+			locker.RUnlock()
+			wg.Wait()
+			assert.Equal(t, uint32(1), atomic.LoadUint32(&writerLocked))
+		})
+		t.Run("negative", func(t *testing.T) {
+			t.Run("notLocked", func(t *testing.T) {
+				var result interface{}
+				func() {
+					defer func() {
+						result = recover()
+					}()
+					locker := &RWMutex{}
+					locker.RLock()
+					locker.Downgrade()
+				}()
+				assert.NotNil(t, result)
+			})
+		})
+	})
 	t.Run("LockTryDo", func(t *testing.T) {
 		t.Run("true", func(t *testing.T) {
 			locker := &RWMutex{}
@@ -528,4 +709,38 @@ func TestRWMutex(t *testing.T) {
 			wg0.Done()
 		})
 	})
+	t.Run("Waiters", func(t *testing.T) {
+		locker := &RWMutex{}
+		assert.Empty(t, locker.Waiters())
+		var wg sync.WaitGroup
+		locker.LockDo(func() {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				locker.RLockDo(func() {})
+			}()
+			for len(locker.Waiters()) < 1 {
+				time.Sleep(time.Millisecond)
+			}
+			go func() {
+				defer wg.Done()
+				locker.LockDo(func() {})
+			}()
+			for len(locker.Waiters()) < 2 {
+				time.Sleep(time.Millisecond)
+			}
+
+			waiters := locker.Waiters()
+			if assert.Len(t, waiters, 2) {
+				assert.False(t, waiters[0].IsWrite)
+				assert.True(t, waiters[1].IsWrite)
+				for _, waiter := range waiters {
+					assert.False(t, waiter.Since.IsZero())
+					assert.NotEmpty(t, waiter.Stack)
+				}
+			}
+		})
+		wg.Wait()
+		assert.Empty(t, locker.Waiters())
+	})
 }
@@ -0,0 +1,203 @@
+package gorex
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UpgradeableRLock is analog of RLock, but additionally it takes the
+// "upgradeable" slot, which allows to Upgrade the read lock to a write
+// lock later.
+//
+// At most one goroutine can hold the upgradeable slot at a time, so
+// upgrading cannot deadlock between two upgradeable readers (in contrast to
+// calling Lock inside RLockDo from multiple goroutines). Plain readers
+// are not blocked by an upgradeable reader.
+//
+// It should be released with UpgradeableRUnlock.
+func (m *RWMutex) UpgradeableRLock() {
+	m.upgradeableRLock(nil, GetGoroutineID(), true)
+}
+
+// UpgradeableRLockTry is analog of UpgradeableRLock(), but it does not block
+// if it cannot lock right away.
+//
+// Returns `false` if was unable to lock.
+func (m *RWMutex) UpgradeableRLockTry() bool {
+	return m.upgradeableRLock(nil, GetGoroutineID(), false)
+}
+
+// UpgradeableRLockCtx is analog of UpgradeableRLock(), but allows to continue
+// the try to lock only until context is done.
+//
+// If the context carries a lock owner (see WithOwner), then the lock is
+// acquired by this owner instead of the calling goroutine (and it should be
+// released by UpgradeableRUnlockCtx with a context carrying the same owner).
+//
+// Returns `false` if was unable to lock.
+func (m *RWMutex) UpgradeableRLockCtx(ctx context.Context) bool {
+	return m.upgradeableRLock(ctx, lockOwnerID(ctx), true)
+}
+
+func (m *RWMutex) upgradeableRLock(
+	ctx context.Context,
+	me GoroutineID,
+	shouldWait bool,
+) bool {
+	m.lazyInit()
+	if shouldWait {
+		lockOrderBeforeLock(m, me, true)
+	}
+	metrics := m.metrics()
+
+	isInfiniteContext := false
+	if ctx == nil {
+		ctx = m.infiniteContext()
+		isInfiniteContext = true
+	}
+
+	var waitStartedAt time.Time
+	var w *lockWaiter
+	m.internalLocker.Lock()
+	for !m.canUpgradeableRLock(me) {
+		if !shouldWait {
+			m.internalLocker.Unlock()
+			metricsTryLockFailed(metrics, m, false)
+			return false
+		}
+		w = m.waiters.push(me, lockWaiterKindUpgradeable, false, w)
+		if !m.wait(&ctx, w, isInfiniteContext, &waitStartedAt) {
+			contentionRecord(waitStartedAt)
+			metricsTimedOut(metrics, m, false, waitStartedAt)
+			return false
+		}
+		if w.isGranted {
+			break
+		}
+	}
+
+	var isFirstRead bool
+	if w != nil && w.isGranted {
+		// the slot and the read lock are already set by grantWaiters
+		isFirstRead = w.isFirstRead
+		if isFirstRead {
+			m.startReading(me, 2)
+		}
+	} else {
+		m.upgradeableBy = me
+		m.upgradeableCount++
+		isFirstRead = m.incMyReaders(me, 2)
+	}
+	m.internalLocker.Unlock()
+	lockOrderLocked(m, me, true)
+	contentionRecord(waitStartedAt)
+	if isFirstRead {
+		metricsAcquired(metrics, m, false, waitStartedAt)
+		traceHoldStart(m.Name)
+	} else {
+		metricsReacquired(metrics, m, false)
+	}
+	return true
+}
+
+// UpgradeableRUnlock releases the lock acquired by UpgradeableRLock.
+//
+// If this is the last upgradeable read lock of the goroutine then
+// the upgradeable slot is released as well.
+func (m *RWMutex) UpgradeableRUnlock() {
+	m.upgradeableRUnlock(GetGoroutineID())
+}
+
+// UpgradeableRUnlockCtx is analog of UpgradeableRUnlock(), but for the lock
+// acquired by UpgradeableRLockCtx with a context carrying a lock owner
+// (see WithOwner).
+func (m *RWMutex) UpgradeableRUnlockCtx(ctx context.Context) {
+	m.upgradeableRUnlock(lockOwnerID(ctx))
+}
+
+func (m *RWMutex) upgradeableRUnlock(me GoroutineID) {
+	m.internalLocker.Lock()
+	switch {
+	case m.upgradeableBy == 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to UpgradeableRUnlock() a not UpgradeableRLock()-ed mutex.")
+	case me != m.upgradeableBy:
+		m.internalLocker.Unlock()
+		panic(fmt.Sprintf("I'm not the one, who UpgradeableRLock()-ed this mutex: %X != %X", me, m.upgradeableBy))
+	case m.upgradeableCount == 1 && m.upgradeDepth > 0:
+		m.internalLocker.Unlock()
+		panic("An attempt to UpgradeableRUnlock() an upgraded mutex, call Downgrade() first.")
+	}
+
+	heldSince := m.decMyReaders(me)
+	m.upgradeableCount--
+	if m.upgradeableCount == 0 {
+		m.upgradeableBy = 0
+		m.wakeUpWaiters()
+	}
+	m.internalLocker.Unlock()
+	lockOrderUnlocked(m, me, true)
+	metricsReleased(m.metrics(), m, false, heldSince)
+	traceHoldEnd(m.Name, heldSince)
+}
+
+// canUpgradeableRLock returns true if an upgradeable read lock could be
+// acquired by "me" right away.
+func (m *RWMutex) canUpgradeableRLock(me GoroutineID) bool {
+	if m.upgradeableBy != 0 && m.upgradeableBy != me {
+		return false
+	}
+	return m.canRLock(me)
+}
+
+// UpgradeableRLockDo is a wrapper around UpgradeableRLock and UpgradeableRUnlock.
+//
+// If fn panics, then the lock is released anyway (as by RLockDo).
+//
+// See also RLockDo and UpgradeableRLock.
+func (m *RWMutex) UpgradeableRLockDo(fn func()) {
+	m.UpgradeableRLock()
+	defer m.UpgradeableRUnlock()
+
+	fn()
+}
+
+// Upgrade atomically promotes the upgradeable read lock (see UpgradeableRLock)
+// to a write lock. It waits until other readers will release their locks,
+// and no other writer could acquire the lock meanwhile.
+//
+// The write lock should be released with Downgrade (to return back to
+// the upgradeable read lock) or with Unlock.
+func (m *RWMutex) Upgrade() {
+	m.upgrade(nil, GetGoroutineID())
+}
+
+// UpgradeCtx is analog of Upgrade(), but allows to continue the try to lock
+// only until context is done.
+//
+// If the context carries a lock owner (see WithOwner), then the upgradeable
+// read lock of this owner is upgraded.
+//
+// Returns `false` if was unable to lock (the upgradeable read lock is kept).
+func (m *RWMutex) UpgradeCtx(ctx context.Context) bool {
+	return m.upgrade(ctx, lockOwnerID(ctx))
+}
+
+func (m *RWMutex) upgrade(ctx context.Context, me GoroutineID) bool {
+	m.internalLocker.Lock()
+	upgradeableBy := m.upgradeableBy
+	m.internalLocker.Unlock()
+	if upgradeableBy != me {
+		panic(fmt.Sprintf("Upgrade()-ing not UpgradeableRLock()-ed mutex: %X != %X", me, upgradeableBy))
+	}
+
+	if !m.lock(ctx, me, true) {
+		return false
+	}
+
+	m.internalLocker.Lock()
+	m.upgradeDepth++
+	m.internalLocker.Unlock()
+	return true
+}
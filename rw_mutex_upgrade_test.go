@@ -0,0 +1,135 @@
+package gorex
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRWMutexUpgrade(t *testing.T) {
+	t.Run("positive", func(t *testing.T) {
+		t.Run("concurrentUpgrades", func(t *testing.T) {
+			locker := &RWMutex{}
+			counter := 0
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						locker.UpgradeableRLockDo(func() {
+							locker.Upgrade()
+							counter++
+							locker.Downgrade()
+						})
+					}
+				}()
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						locker.RLockDo(func() {
+							_ = counter
+						})
+					}
+				}()
+			}
+			wg.Wait()
+			assert.Equal(t, 1000, counter)
+		})
+		t.Run("reentrant", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.UpgradeableRLockDo(func() {
+				locker.UpgradeableRLockDo(func() {
+					locker.Upgrade()
+					locker.LockDo(func() {
+						locker.RLockDo(func() {})
+					})
+					locker.Downgrade()
+				})
+				locker.Upgrade()
+				locker.Downgrade()
+			})
+			assert.True(t, locker.LockTry())
+			locker.Unlock()
+		})
+		t.Run("plainReadersAreNotBlocked", func(t *testing.T) {
+			locker := &RWMutex{}
+			locker.UpgradeableRLock()
+			defer locker.UpgradeableRUnlock()
+
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.True(t, locker.RLockTry())
+				locker.RUnlock()
+				assert.False(t, locker.UpgradeableRLockTry())
+				assert.False(t, locker.LockTry())
+			}()
+			wg.Wait()
+		})
+		t.Run("upgradeWaitsForReaders", func(t *testing.T) {
+			locker := &RWMutex{}
+			var wg0, wg1 sync.WaitGroup
+			wg0.Add(1)
+			wg1.Add(1)
+			go locker.RLockDo(func() {
+				wg1.Done()
+				wg0.Wait()
+			})
+			wg1.Wait()
+
+			locker.UpgradeableRLockDo(func() {
+				ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+				defer cancelFn()
+				assert.False(t, locker.UpgradeCtx(ctx))
+				wg0.Done()
+				locker.Upgrade()
+				locker.Downgrade()
+			})
+		})
+	})
+	t.Run("negative", func(t *testing.T) {
+		t.Run("UpgradeWithoutUpgradeableRLock", func(t *testing.T) {
+			var result interface{}
+			func() {
+				defer func() {
+					result = recover()
+				}()
+				locker := &RWMutex{}
+				locker.RLock()
+				locker.Upgrade()
+			}()
+			assert.NotNil(t, result)
+		})
+		t.Run("DowngradeWithoutUpgrade", func(t *testing.T) {
+			var result interface{}
+			func() {
+				defer func() {
+					result = recover()
+				}()
+				locker := &RWMutex{}
+				locker.UpgradeableRLock()
+				locker.Downgrade()
+			}()
+			assert.NotNil(t, result)
+		})
+		t.Run("UpgradeableRUnlockWhileUpgraded", func(t *testing.T) {
+			var result interface{}
+			func() {
+				defer func() {
+					result = recover()
+				}()
+				locker := &RWMutex{}
+				locker.UpgradeableRLock()
+				locker.Upgrade()
+				locker.UpgradeableRUnlock()
+			}()
+			assert.NotNil(t, result)
+		})
+	})
+}
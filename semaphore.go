@@ -0,0 +1,305 @@
+package gorex
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/xaionaro-go/spinlock"
+)
+
+// Semaphore is a goroutine-aware analog of a weighted semaphore (like
+// golang.org/x/sync/semaphore.Weighted), so it tracks which goroutines hold
+// permits. So a goroutine which already holds permits could acquire them
+// again (for example, in a recursive call) without consuming additional
+// capacity.
+//
+// Waiting goroutines are served in FIFO order, so a goroutine waiting for
+// many permits is not starved by goroutines waiting for few permits.
+//
+// A Semaphore should be created by NewSemaphore: unlike Mutex and RWMutex,
+// the zero-value is not usable (it has no permits, so Acquire panics).
+type Semaphore struct {
+	// InfiniteContext is used as the default context used on any try to acquire if
+	// a custom context is not set (see Acquire), but with the difference
+	// if this context will be done, then it will panic with debugging information.
+	//
+	// To specify a context with deadline may be useful for unit tests.
+	//
+	// The zero-value means to use DefaultInfiniteContext.
+	InfiniteContext context.Context
+
+	// OnDeadlock is called when InfiniteContext is done. If the handler
+	// returns, then the goroutine continues to wait for the permits.
+	//
+	// The zero-value means to use the package-level OnDeadlock.
+	OnDeadlock func(*DeadlockReport)
+
+	size           int64
+	internalLocker spinlock.Locker
+	used           int64
+	holders        map[GoroutineID]*semaphoreHolder
+	waiters        lockWaiterQueue
+}
+
+// semaphoreHolder is the information about a goroutine holding permits.
+type semaphoreHolder struct {
+	// acquisitions is the amounts of permits passed to Acquire (in the order
+	// of the calls) which are not released, yet.
+	acquisitions []int64
+
+	// permits is the amount of permits consumed by the goroutine (the maximum
+	// of acquisitions).
+	permits int64
+
+	stack []uintptr
+}
+
+// NewSemaphore returns a new Semaphore with "size" permits.
+func NewSemaphore(size int64) *Semaphore {
+	if size <= 0 {
+		panic(fmt.Sprintf("The size of a semaphore should be positive, but it is %d.", size))
+	}
+	return &Semaphore{
+		size:    size,
+		holders: map[GoroutineID]*semaphoreHolder{},
+	}
+}
+
+// Size returns the maximal amount of permits which could be held simultaneously.
+func (s *Semaphore) Size() int64 {
+	return s.size
+}
+
+// Acquire acquires "n" permits, blocking until they are available or
+// the context is done.
+//
+// If the goroutine already holds permits, then only the lacking permits are
+// consumed. For example, a goroutine holding 2 permits could acquire 1 or 2
+// permits again without consuming any capacity, while acquiring 3 permits
+// consumes one more permit. Every Acquire should be paired with Release
+// with the same "n" (in the reverse order).
+//
+// If the context is nil, then InfiniteContext is used, but with the difference
+// if this context will be done, then it will panic with debugging information.
+//
+// If the context carries a lock owner (see WithOwner), then the permits are
+// acquired by this owner instead of the calling goroutine (and they should be
+// released by ReleaseCtx with a context carrying the same owner).
+//
+// Returns `false` if was unable to acquire (context finished before it was possible to acquire).
+func (s *Semaphore) Acquire(ctx context.Context, n int64) bool {
+	return s.acquire(ctx, lockOwnerID(ctx), n, true)
+}
+
+// TryAcquire is analog of Acquire(), but it does not block if it cannot
+// acquire right away.
+//
+// Returns `false` if was unable to acquire.
+func (s *Semaphore) TryAcquire(n int64) bool {
+	return s.acquire(nil, GetGoroutineID(), n, false)
+}
+
+func (s *Semaphore) infiniteContext() context.Context {
+	if s.InfiniteContext == nil {
+		return DefaultInfiniteContext
+	}
+	return s.InfiniteContext
+}
+
+func (s *Semaphore) acquire(ctx context.Context, me GoroutineID, n int64, shouldWait bool) bool {
+	if n < 0 {
+		panic(fmt.Sprintf("An attempt to acquire a negative amount of permits: %d.", n))
+	}
+	isInfiniteContext := false
+	if ctx == nil {
+		ctx = s.infiniteContext()
+		isInfiniteContext = true
+	}
+
+	s.internalLocker.Lock()
+	if s.size == 0 {
+		s.internalLocker.Unlock()
+		panic("An attempt to acquire a semaphore, which is not created by NewSemaphore().")
+	}
+	if n > s.size {
+		s.internalLocker.Unlock()
+		panic(fmt.Sprintf("An attempt to acquire %d permits of a semaphore of size %d.", n, s.size))
+	}
+	holder := s.holders[me]
+	lacking := s.lackingPermits(me, n)
+	// A holder could not wait behind other goroutines, they could wait
+	// for its permits.
+	if lacking == 0 || (s.used+lacking <= s.size && (holder != nil || s.waiters.isEmpty())) {
+		s.addAcquisition(me, n, lacking)
+		s.recordStack(me, 2)
+		s.internalLocker.Unlock()
+		return true
+	}
+	if !shouldWait {
+		s.internalLocker.Unlock()
+		return false
+	}
+
+	w := s.waiters.push(me, lockWaiterKindPermits, holder != nil, nil)
+	w.permits = n
+	s.internalLocker.Unlock()
+	isWoken := w.wait(&ctx, isInfiniteContext, s)
+	s.internalLocker.Lock()
+	if !isWoken && !w.isGranted {
+		s.waiters.remove(w)
+		// the waiters behind could be granted now
+		s.grantWaiters()
+		s.internalLocker.Unlock()
+		return false
+	}
+	// the permits are already consumed by grantWaiters
+	s.recordStack(me, 2)
+	s.internalLocker.Unlock()
+	return true
+}
+
+// lackingPermits returns how many permits should be consumed by "me" to
+// acquire "n" permits. It should be called with locked internalLocker.
+func (s *Semaphore) lackingPermits(me GoroutineID, n int64) int64 {
+	if holder := s.holders[me]; holder != nil {
+		n -= holder.permits
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// addAcquisition records an acquisition of "n" permits by "me" (which consumes
+// "lacking" permits). It should be called with locked internalLocker.
+func (s *Semaphore) addAcquisition(me GoroutineID, n, lacking int64) {
+	holder := s.holders[me]
+	if holder == nil {
+		holder = &semaphoreHolder{}
+		s.holders[me] = holder
+	}
+	holder.acquisitions = append(holder.acquisitions, n)
+	holder.permits += lacking
+	s.used += lacking
+}
+
+// recordStack records the acquisition stack of "me" if it is the first
+// acquisition. It should be called with locked internalLocker.
+//
+// "skip" is the number of stack frames to skip, where 0 identifies the caller
+// of recordStack.
+func (s *Semaphore) recordStack(me GoroutineID, skip int) {
+	holder := s.holders[me]
+	if len(holder.acquisitions) != 1 {
+		return
+	}
+	holder.stack = acquisitionStack(holder.stack, skip+1)
+}
+
+// grantWaiters hands the permits to the waiters from the head of the queue
+// while there are enough free permits. It should be called with locked
+// internalLocker.
+func (s *Semaphore) grantWaiters() {
+	for {
+		w := s.waiters.head()
+		if w == nil {
+			return
+		}
+		lacking := s.lackingPermits(w.me, w.permits)
+		if s.used+lacking > s.size {
+			return
+		}
+		s.waiters.pop()
+		s.addAcquisition(w.me, w.permits, lacking)
+		w.grant()
+	}
+}
+
+// Release releases "n" permits acquired by the last Acquire of
+// the calling goroutine.
+func (s *Semaphore) Release(n int64) {
+	s.release(GetGoroutineID(), n)
+}
+
+// ReleaseCtx is analog of Release(), but for the permits acquired by Acquire
+// with a context carrying a lock owner (see WithOwner).
+//
+// If the context does not carry a lock owner, then it is the same as Release().
+func (s *Semaphore) ReleaseCtx(ctx context.Context, n int64) {
+	s.release(lockOwnerID(ctx), n)
+}
+
+func (s *Semaphore) release(me GoroutineID, n int64) {
+	s.internalLocker.Lock()
+	holder := s.holders[me]
+	if holder == nil {
+		s.internalLocker.Unlock()
+		panic(fmt.Sprintf("An attempt to release a semaphore, which is not acquired by %X.", me))
+	}
+	last := holder.acquisitions[len(holder.acquisitions)-1]
+	if last != n {
+		s.internalLocker.Unlock()
+		panic(fmt.Sprintf("An attempt to release %d permits, but the last Acquire() acquired %d permits.", n, last))
+	}
+
+	holder.acquisitions = holder.acquisitions[:len(holder.acquisitions)-1]
+	permits := int64(0)
+	for _, acquired := range holder.acquisitions {
+		if acquired > permits {
+			permits = acquired
+		}
+	}
+	s.used -= holder.permits - permits
+	holder.permits = permits
+	if len(holder.acquisitions) == 0 {
+		delete(s.holders, me)
+	}
+	s.grantWaiters()
+	s.internalLocker.Unlock()
+}
+
+// AcquireDo is a wrapper around Acquire and ReleaseCtx.
+//
+// See also Acquire.
+func (s *Semaphore) AcquireDo(ctx context.Context, n int64, fn func()) (success bool) {
+	if !s.Acquire(ctx, n) {
+		return false
+	}
+	defer s.ReleaseCtx(ctx, n)
+
+	success = true
+	fn()
+	return
+}
+
+// Waiters returns the goroutines which currently wait for permits
+// (in the order of waiting, but holders go first).
+func (s *Semaphore) Waiters() []DeadlockWaiter {
+	s.internalLocker.Lock()
+	defer s.internalLocker.Unlock()
+	return s.waiters.deadlockWaiters()
+}
+
+func (s *Semaphore) debugPanic() {
+	s.internalLocker.Lock()
+	report := &DeadlockReport{
+		Semaphore: s,
+	}
+	for g, holder := range s.holders {
+		report.Holders = append(report.Holders, DeadlockHolder{
+			GoroutineID: g,
+			Permits:     holder.permits,
+			Depth:       len(holder.acquisitions),
+			Stack:       stackFrames(holder.stack),
+		})
+	}
+	report.Waiters = s.waiters.deadlockWaiters()
+	onDeadlock := s.OnDeadlock
+	s.internalLocker.Unlock()
+
+	sort.Slice(report.Holders, func(i, j int) bool {
+		return report.Holders[i].GoroutineID < report.Holders[j].GoroutineID
+	})
+	debugPanic(report, onDeadlock)
+}
@@ -0,0 +1,186 @@
+package gorex
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSemaphore(t *testing.T) {
+	// used returns the amount of consumed permits.
+	used := func(s *Semaphore) int64 {
+		s.internalLocker.Lock()
+		defer s.internalLocker.Unlock()
+		return s.used
+	}
+	// waitForQueueLen waits until the queue of the semaphore has "count" waiters.
+	waitForQueueLen := func(s *Semaphore, count int) {
+		for {
+			s.internalLocker.Lock()
+			l := len(s.waiters.waiters)
+			s.internalLocker.Unlock()
+			if l == count {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	timeoutCtx := func() context.Context {
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Millisecond)
+		t.Cleanup(cancelFn)
+		return ctx
+	}
+
+	t.Run("reentrant", func(t *testing.T) {
+		s := NewSemaphore(3)
+		assert.True(t, s.Acquire(nil, 2))
+		assert.True(t, s.Acquire(nil, 1))
+		assert.True(t, s.TryAcquire(2))
+		assert.Equal(t, int64(2), used(s))
+		assert.True(t, s.Acquire(nil, 3))
+		assert.Equal(t, int64(3), used(s))
+
+		s.Release(3)
+		assert.Equal(t, int64(2), used(s))
+		s.Release(2)
+		s.Release(1)
+		assert.Equal(t, int64(2), used(s))
+		s.Release(2)
+		assert.Equal(t, int64(0), used(s))
+	})
+	t.Run("exclusion", func(t *testing.T) {
+		s := NewSemaphore(3)
+		assert.True(t, s.Acquire(nil, 2))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.False(t, s.TryAcquire(2))
+			assert.False(t, s.Acquire(timeoutCtx(), 2))
+			assert.True(t, s.AcquireDo(context.Background(), 1, func() {
+				assert.Equal(t, int64(3), used(s))
+			}))
+		}()
+		wg.Wait()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, s.Acquire(nil, 3))
+			s.Release(3)
+		}()
+		waitForQueueLen(s, 1)
+		if waiters := s.Waiters(); assert.Len(t, waiters, 1) {
+			assert.Equal(t, int64(3), waiters[0].Permits)
+			assert.NotEmpty(t, waiters[0].Stack)
+		}
+		s.Release(2)
+		wg.Wait()
+		assert.Equal(t, int64(0), used(s))
+		assert.Empty(t, s.Waiters())
+	})
+	t.Run("FIFO", func(t *testing.T) {
+		s := NewSemaphore(3)
+		assert.True(t, s.Acquire(nil, 1))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, s.Acquire(nil, 3))
+			s.Release(3)
+		}()
+		waitForQueueLen(s, 1)
+
+		// there is a free permit, but the goroutine waiting for all
+		// the permits is not overtaken
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.False(t, s.TryAcquire(1))
+		}()
+		<-done
+
+		// but the holder is not queued behind the waiters
+		assert.True(t, s.TryAcquire(2))
+		s.Release(2)
+
+		s.Release(1)
+		wg.Wait()
+	})
+	t.Run("WithOwner", func(t *testing.T) {
+		s := NewSemaphore(1)
+		ctx := WithOwner(context.Background())
+		assert.True(t, s.Acquire(ctx, 1))
+		assert.False(t, s.TryAcquire(1))
+		assert.Panics(t, func() { s.Release(1) })
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, s.AcquireDo(ctx, 1, func() {}))
+		}()
+		wg.Wait()
+		s.ReleaseCtx(ctx, 1)
+		assert.Equal(t, int64(0), used(s))
+	})
+	t.Run("negative", func(t *testing.T) {
+		assert.Panics(t, func() { NewSemaphore(0) })
+
+		s := NewSemaphore(2)
+		assert.Panics(t, func() { s.Release(1) })
+		assert.Panics(t, func() { s.Acquire(nil, 3) })
+		assert.Panics(t, func() { s.Acquire(nil, -1) })
+		assert.True(t, s.Acquire(nil, 1))
+		assert.Panics(t, func() { s.Release(2) })
+		s.Release(1)
+	})
+	t.Run("zero_value", func(t *testing.T) {
+		s := &Semaphore{}
+		assert.PanicsWithValue(t, "An attempt to acquire a semaphore, which is not created by NewSemaphore().",
+			func() { s.Acquire(nil, 1) })
+		assert.Panics(t, func() { s.TryAcquire(0) })
+		assert.Panics(t, func() { s.Release(1) })
+	})
+	t.Run("InfiniteContext", func(t *testing.T) {
+		s := NewSemaphore(2)
+		var cancelFn context.CancelFunc
+		s.InfiniteContext, cancelFn = context.WithDeadline(context.Background(), time.Now())
+		defer cancelFn()
+		reportCh := make(chan *DeadlockReport, 1)
+		s.OnDeadlock = func(report *DeadlockReport) {
+			reportCh <- report
+		}
+		assert.True(t, s.Acquire(nil, 2))
+		assert.True(t, s.Acquire(nil, 1))
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Acquire(nil, 1)
+			s.Release(1)
+		}()
+		report := <-reportCh
+		s.Release(1)
+		s.Release(2)
+		wg.Wait()
+
+		assert.Equal(t, s, report.Semaphore)
+		if assert.Len(t, report.Holders, 1) {
+			assert.Equal(t, GetGoroutineID(), report.Holders[0].GoroutineID)
+			assert.Equal(t, int64(2), report.Holders[0].Permits)
+			assert.Equal(t, 2, report.Holders[0].Depth)
+		}
+		if assert.Len(t, report.Waiters, 1) {
+			assert.Equal(t, int64(1), report.Waiters[0].Permits)
+		}
+		assert.True(t, strings.Contains(report.String(), "2 permits (acquired 2 times) by goroutine"), report.String())
+	})
+}
@@ -0,0 +1,209 @@
+package gorex
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var acquisitionStackDepth int32 = MaxStackTrace
+
+// SetAcquisitionStackDepth sets the maximal depth of the call stack trace
+// recorded on every (non-reentrant) acquisition of a Mutex/RWMutex lock
+// (and on every start of waiting for a lock). These call stack traces are
+// used in deadlock diagnostics (see DeadlockReport).
+//
+// Zero disables the recording. The default value is MaxStackTrace.
+func SetAcquisitionStackDepth(depth int) {
+	atomic.StoreInt32(&acquisitionStackDepth, int32(depth))
+}
+
+// acquisitionStack records the call stack trace into "buf" (reusing its
+// memory if possible). "skip" is the number of stack frames to skip,
+// where 0 identifies the caller of acquisitionStack.
+func acquisitionStack(buf []uintptr, skip int) []uintptr {
+	depth := int(atomic.LoadInt32(&acquisitionStackDepth))
+	if depth <= 0 {
+		return buf[:0]
+	}
+	if cap(buf) < depth {
+		buf = make([]uintptr, depth)
+	}
+	n := runtime.Callers(skip+2, buf[:depth])
+	return buf[:n]
+}
+
+// stackFrames converts program counters to stack frames.
+func stackFrames(pcs []uintptr) []StackFrame {
+	if len(pcs) == 0 {
+		return nil
+	}
+	result := make([]StackFrame, 0, len(pcs))
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+		result = append(result, StackFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+		})
+		if !more {
+			break
+		}
+	}
+	return result
+}
+
+// callers returns the program counters of the call stack of the calling
+// goroutine. "skip" is the number of stack frames to skip, where 0 identifies
+// the caller of callers.
+func callers(skip int, maxDepth int) []uintptr {
+	pcs := make([]uintptr, maxDepth)
+	n := runtime.Callers(skip+2, pcs)
+	return pcs[:n]
+}
+
+// printFrames writes the frames in the format "file:line (function)", one
+// frame per line.
+func printFrames(out io.Writer, frames *runtime.Frames) {
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(out, "%s:%d (%s)\n", frame.File, frame.Line, frame.Function)
+		if !more {
+			break
+		}
+	}
+}
+
+// printStack is the same as printFrames, but accepts program counters.
+func printStack(out io.Writer, pcs []uintptr) {
+	if len(pcs) == 0 {
+		fmt.Fprintf(out, "<no stack trace>\n")
+		return
+	}
+	printFrames(out, runtime.CallersFrames(pcs))
+}
+
+// StackFrame is a single frame of a call stack trace.
+type StackFrame struct {
+	// Function is the fully qualified name of the function.
+	Function string
+
+	// File is the path to the source file.
+	File string
+
+	// Line is the line number in File.
+	Line int
+}
+
+// String implements fmt.Stringer.
+func (frame StackFrame) String() string {
+	return fmt.Sprintf("%s:%d (%s)", frame.File, frame.Line, frame.Function)
+}
+
+// packagePath is the import path of this package, it is used to skip
+// the frames of the package in call stack traces.
+var packagePath = reflect.TypeOf(Mutex{}).PkgPath()
+
+// callerFrame returns the first frame of the call stack trace which is not
+// a frame of this package (for example, the place where a goroutine called
+// Lock), or the first frame if there is no such frame.
+//
+// Returns `false` if the stack is empty.
+func callerFrame(frames []StackFrame) (StackFrame, bool) {
+	if len(frames) == 0 {
+		return StackFrame{}, false
+	}
+	for _, frame := range frames {
+		if !strings.HasPrefix(frame.Function, packagePath+".") || strings.HasSuffix(frame.File, "_test.go") {
+			return frame, true
+		}
+	}
+	return frames[0], true
+}
+
+// GoroutineStack is a call stack trace of a goroutine.
+type GoroutineStack struct {
+	// ID is the ID of the goroutine.
+	ID GoroutineID
+
+	// State is the state of the goroutine, for example "running"
+	// or "chan receive, 2 minutes".
+	State string
+
+	// Frames is the call stack trace, starting from the innermost frame.
+	Frames []StackFrame
+
+	// CreatedBy is the frame of the "go" statement which started the
+	// goroutine (if known).
+	CreatedBy *StackFrame `json:",omitempty"`
+}
+
+// parseGoroutineStacks parses the output of runtime.Stack.
+func parseGoroutineStacks(b []byte) []GoroutineStack {
+	var result []GoroutineStack
+	for _, block := range strings.Split(string(b), "\n\n") {
+		lines := strings.Split(strings.TrimSpace(block), "\n")
+		if !strings.HasPrefix(lines[0], "goroutine ") {
+			continue
+		}
+
+		var g GoroutineStack
+		header := strings.TrimSuffix(strings.TrimPrefix(lines[0], "goroutine "), ":")
+		if idx := strings.Index(header, " ["); idx >= 0 {
+			g.State = strings.TrimSuffix(header[idx+2:], "]")
+			header = header[:idx]
+		}
+		g.ID, _ = strconv.ParseUint(header, 10, 64)
+
+		for idx := 1; idx < len(lines); idx++ {
+			function := strings.TrimSpace(lines[idx])
+			if !strings.HasSuffix(function, ")") && !strings.HasPrefix(function, "created by ") {
+				// for example "...additional frames elided..."
+				continue
+			}
+
+			var frame StackFrame
+			if idx+1 < len(lines) && strings.HasPrefix(lines[idx+1], "\t") {
+				idx++
+				frame.File, frame.Line = parseStackFileLine(lines[idx])
+			}
+
+			if strings.HasPrefix(function, "created by ") {
+				function = strings.TrimPrefix(function, "created by ")
+				if pos := strings.Index(function, " in goroutine "); pos >= 0 {
+					function = function[:pos]
+				}
+				frame.Function = function
+				g.CreatedBy = &frame
+				continue
+			}
+
+			if pos := strings.LastIndex(function, "("); pos > 0 {
+				function = function[:pos]
+			}
+			frame.Function = function
+			g.Frames = append(g.Frames, frame)
+		}
+		result = append(result, g)
+	}
+	return result
+}
+
+// parseStackFileLine parses lines like "\t/path/to/file.go:123 +0x1d".
+func parseStackFileLine(line string) (string, int) {
+	line = strings.TrimSpace(line)
+	if pos := strings.LastIndex(line, " +0x"); pos >= 0 {
+		line = line[:pos]
+	}
+	pos := strings.LastIndex(line, ":")
+	if pos < 0 {
+		return line, 0
+	}
+	lineNumber, _ := strconv.Atoi(line[pos+1:])
+	return line[:pos], lineNumber
+}
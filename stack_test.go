@@ -0,0 +1,58 @@
+package gorex
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGoroutineStacks(t *testing.T) {
+	b := make([]byte, 1024*1024)
+	b = b[:runtime.Stack(b, true)]
+
+	me := GetGoroutineID()
+	var myStack *GoroutineStack
+	goroutines := parseGoroutineStacks(b)
+	for idx := range goroutines {
+		if goroutines[idx].ID == me {
+			myStack = &goroutines[idx]
+		}
+	}
+	if !assert.NotNil(t, myStack) {
+		return
+	}
+	assert.Equal(t, "running", myStack.State)
+	if !assert.NotEmpty(t, myStack.Frames) {
+		return
+	}
+	assert.Equal(t, "github.com/xaionaro-go/gorex.TestParseGoroutineStacks", myStack.Frames[0].Function)
+	assert.True(t, strings.HasSuffix(myStack.Frames[0].File, "stack_test.go"), myStack.Frames[0].File)
+	assert.NotZero(t, myStack.Frames[0].Line)
+	if assert.NotNil(t, myStack.CreatedBy) {
+		assert.Equal(t, "testing.(*T).Run", myStack.CreatedBy.Function)
+	}
+}
+
+func TestAcquisitionStack(t *testing.T) {
+	t.Run("positive", func(t *testing.T) {
+		locker := &RWMutex{}
+		locker.Lock()
+		defer locker.Unlock()
+
+		frames := stackFrames(locker.lockedByStack)
+		if assert.NotEmpty(t, frames) {
+			assert.Equal(t, "github.com/xaionaro-go/gorex.TestAcquisitionStack.func1", frames[0].Function)
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		SetAcquisitionStackDepth(0)
+		defer SetAcquisitionStackDepth(MaxStackTrace)
+
+		locker := &Mutex{}
+		locker.Lock()
+		defer locker.Unlock()
+		assert.Empty(t, locker.monopolizedStack)
+	})
+}
@@ -0,0 +1,85 @@
+package gorex
+
+import (
+	"context"
+	"fmt"
+	"runtime/trace"
+	"sync/atomic"
+	"time"
+)
+
+var executionTracingEnabled uint32
+
+// SetExecutionTracing enables (or disables) the integration with
+// the execution tracer (see package runtime/trace and "go tool trace").
+//
+// If enabled (and the tracer is running), then waiting for a lock of
+// a Mutex or RWMutex is shown as region "gorex.wait <name>" (otherwise it
+// is shown only as an anonymous block on a channel), and the acquisition
+// and the release of the lock are logged with category "gorex.hold <name>"
+// (the message of the release contains how long the lock was held), where
+// <name> is the Name of the mutex.
+//
+// Holding is logged instead of being a region, because regions should be
+// strictly nested and ended by the same goroutine, while locks are not
+// always released in this order (and could be handed off, see
+// Mutex.HandOff).
+func SetExecutionTracing(enable bool) {
+	var v uint32
+	if enable {
+		v = 1
+	}
+	atomic.StoreUint32(&executionTracingEnabled, v)
+}
+
+func isExecutionTracingEnabled() bool {
+	return atomic.LoadUint32(&executionTracingEnabled) != 0 && trace.IsEnabled()
+}
+
+// traceName returns the name of the region or the log category of
+// the mutex named "name".
+func traceName(prefix, name string) string {
+	if name == "" {
+		return prefix
+	}
+	return prefix + " " + name
+}
+
+// traceWaitStart starts the region of waiting for a lock of the mutex
+// named "name".
+//
+// Returns nil if the execution tracing is disabled.
+func traceWaitStart(ctx context.Context, name string) *trace.Region {
+	if !isExecutionTracingEnabled() {
+		return nil
+	}
+	return trace.StartRegion(ctx, traceName("gorex.wait", name))
+}
+
+// traceRegionEnd ends the region (if it is not nil).
+func traceRegionEnd(region *trace.Region) {
+	if region == nil {
+		return
+	}
+	region.End()
+}
+
+// traceHoldStart logs the acquisition of a lock of the mutex named "name".
+func traceHoldStart(name string) {
+	if !isExecutionTracingEnabled() {
+		return
+	}
+	trace.Log(context.Background(), traceName("gorex.hold", name), "acquired")
+}
+
+// traceHoldEnd logs the release of a lock acquired at "heldSince" (see
+// holdStartTime) of the mutex named "name". It does nothing if "heldSince"
+// is zero (the lock is still held, or it was acquired while nobody needed
+// the hold durations).
+func traceHoldEnd(name string, heldSince time.Time) {
+	if heldSince.IsZero() || !isExecutionTracingEnabled() {
+		return
+	}
+	trace.Log(context.Background(), traceName("gorex.hold", name),
+		fmt.Sprintf("released after %v", time.Since(heldSince)))
+}
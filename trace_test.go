@@ -0,0 +1,51 @@
+package gorex
+
+import (
+	"bytes"
+	"runtime/trace"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetExecutionTracing(t *testing.T) {
+	// traceOf returns the execution trace of a contended locking of
+	// a mutex named "name".
+	traceOf := func(t *testing.T, name string) []byte {
+		var buf bytes.Buffer
+		if err := trace.Start(&buf); err != nil {
+			t.Skipf("unable to start tracing: %v", err)
+		}
+		m := &RWMutex{Name: name}
+		var wg sync.WaitGroup
+		m.LockDo(func() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m.RLockDo(func() {})
+			}()
+			for len(m.Waiters()) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+		})
+		wg.Wait()
+		trace.Stop()
+		return buf.Bytes()
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		SetExecutionTracing(true)
+		defer SetExecutionTracing(false)
+		b := traceOf(t, "TestSetExecutionTracing/enabled")
+		assert.True(t, bytes.Contains(b, []byte("gorex.wait TestSetExecutionTracing/enabled")))
+		assert.True(t, bytes.Contains(b, []byte("gorex.hold TestSetExecutionTracing/enabled")))
+		assert.True(t, bytes.Contains(b, []byte("released after ")))
+	})
+	t.Run("disabled", func(t *testing.T) {
+		b := traceOf(t, "TestSetExecutionTracing/disabled")
+		assert.False(t, bytes.Contains(b, []byte("gorex.wait")))
+		assert.False(t, bytes.Contains(b, []byte("gorex.hold")))
+	})
+}
@@ -0,0 +1,362 @@
+package gorex
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WaitForEdge is a fact that goroutine "Waiter" waits for lock "Locker"
+// which is held by goroutine "Owner".
+type WaitForEdge struct {
+	// Waiter is the ID of goroutine which waits for Locker.
+	Waiter GoroutineID
+
+	// Locker is the mutex (*Mutex or *RWMutex).
+	Locker sync.Locker
+
+	// IsWrite is true if Waiter waits for a write lock.
+	IsWrite bool
+
+	// Owner is the ID of goroutine which holds Locker.
+	Owner GoroutineID
+
+	// WaiterStack is the stack trace where Waiter waits for Locker.
+	WaiterStack []uintptr
+
+	// OwnerStack is the stack trace of the acquisition of Locker by Owner.
+	OwnerStack []uintptr
+}
+
+// WaitForCycle is a report about goroutines which wait for each other
+// (a deadlock), found by the wait-for graph detection
+// (see SetWaitForGraphDetection).
+type WaitForCycle struct {
+	// Cycle is the list of edges forming the cycle, the "Owner" of
+	// each edge is the "Waiter" of the next one (and the "Owner" of the last
+	// one is the "Waiter" of the first one).
+	Cycle []WaitForEdge
+}
+
+// WriteTo writes a human-readable report to "out".
+func (c *WaitForCycle) WriteTo(out io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "deadlock: a cycle of %d goroutines waiting for each other:\n", len(c.Cycle))
+	for idx, edge := range c.Cycle {
+		lockType := "read"
+		if edge.IsWrite {
+			lockType = "write"
+		}
+		fmt.Fprintf(&buf, "%d. goroutine %d waits for a %s lock of %p held by goroutine %d.\n",
+			idx+1, edge.Waiter, lockType, edge.Locker, edge.Owner)
+		fmt.Fprintf(&buf, "goroutine %d waits at:\n", edge.Waiter)
+		printStack(&buf, edge.WaiterStack)
+		fmt.Fprintf(&buf, "goroutine %d acquired %p at:\n", edge.Owner, edge.Locker)
+		printStack(&buf, edge.OwnerStack)
+	}
+	return buf.WriteTo(out)
+}
+
+// String implements fmt.Stringer.
+func (c *WaitForCycle) String() string {
+	var buf bytes.Buffer
+	_, _ = c.WriteTo(&buf)
+	return buf.String()
+}
+
+// OnWaitForCycle is called if the wait-for graph detection is enabled
+// (see SetWaitForGraphDetection) and a goroutine starts waiting for a lock
+// which closes a cycle of goroutines waiting for each other.
+//
+// If the handler returns, then the goroutine continues to wait for the lock.
+// The zero-value means to print the report to stderr and panic.
+var OnWaitForCycle func(*WaitForCycle)
+
+var waitForGraphDetectionEnabled uint32
+
+// SetWaitForGraphDetection enables or disables the wait-for graph detection.
+//
+// If enabled, every time a goroutine starts waiting for a Mutex or RWMutex,
+// the chain "waiting goroutine -> the owner of the lock -> the lock this
+// owner waits for -> ..." is walked, and if it leads back to the waiting
+// goroutine, then the deadlock is reported right away via OnWaitForCycle
+// (instead of waiting for InfiniteContext to be done).
+//
+// Only the goroutines holding the locks are considered as owners, waiting
+// behind other waiters (see Fair) is not a part of the graph. The goroutines
+// waiting with a context (LockCtx, LockTimeout, etc) are not a part of
+// the graph after the context is done: they are about to give up waiting.
+//
+// It slows down every waiting, so this mode is supposed to be used only
+// in tests and while debugging.
+func SetWaitForGraphDetection(enable bool) {
+	var v uint32
+	if enable {
+		v = 1
+	}
+	atomic.StoreUint32(&waitForGraphDetectionEnabled, v)
+}
+
+func isWaitForGraphDetectionEnabled() bool {
+	return atomic.LoadUint32(&waitForGraphDetectionEnabled) != 0
+}
+
+// waitForLocker is a mutex which could be a part of the wait-for graph.
+type waitForLocker interface {
+	sync.Locker
+
+	// waitForOwners returns the goroutines holding the lock which prevent
+	// "me" from acquiring the lock of kind "kind".
+	waitForOwners(me GoroutineID, kind lockWaiterKind) []GoroutineID
+
+	// acquisitionStackOf returns a copy of the stack trace of the acquisition
+	// of the lock by goroutine "g".
+	acquisitionStackOf(g GoroutineID) []uintptr
+}
+
+// waitForNode is the information about a goroutine waiting for a lock.
+type waitForNode struct {
+	locker waitForLocker
+	kind   lockWaiterKind
+	stack  []uintptr
+
+	// done and deadline are copied from the context of the waiting (they
+	// are empty for the InfiniteContext, which does not finish the waiting).
+	done     <-chan struct{}
+	deadline time.Time
+}
+
+// isExpired returns true if the context of the waiting is done, so
+// the goroutine is going to stop waiting.
+func (node *waitForNode) isExpired() bool {
+	if !node.deadline.IsZero() && !time.Now().Before(node.deadline) {
+		return true
+	}
+	select {
+	case <-node.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitForStep is an edge of the wait-for graph found by findPath.
+type waitForStep struct {
+	waiter GoroutineID
+	node   *waitForNode
+	owner  GoroutineID
+}
+
+type waitForGraph struct {
+	locker  sync.Mutex
+	waiting map[GoroutineID]*waitForNode
+}
+
+var globalWaitForGraph = waitForGraph{
+	waiting: map[GoroutineID]*waitForNode{},
+}
+
+// waitForGraphWaiting is called when waiter "w" starts waiting for lock "l"
+// until context "ctx" is done (the internalLocker of "l" should be unlocked).
+// It registers the waiting and reports a cycle if it was found.
+//
+// Returns the registered node (which should be passed to waitForGraphWoken)
+// or nil if the detection is disabled.
+func waitForGraphWaiting(
+	l waitForLocker,
+	w *lockWaiter,
+	ctx context.Context,
+	isInfiniteContext bool,
+) *waitForNode {
+	if !isWaitForGraphDetectionEnabled() {
+		return nil
+	}
+	if w.me&virtualOwnerIDBit != 0 {
+		// the lock is waited by a context (see WithOwner), not by a goroutine
+		return nil
+	}
+
+	node := &waitForNode{
+		locker: l,
+		kind:   w.kind,
+		stack:  callers(2, lockOrderStackDepth),
+	}
+	if !isInfiniteContext {
+		node.done = ctx.Done()
+		node.deadline, _ = ctx.Deadline()
+	}
+	g := &globalWaitForGraph
+	g.locker.Lock()
+	g.waiting[w.me] = node
+	g.locker.Unlock()
+
+	if cycle := g.findCycle(w.me); cycle != nil {
+		reportWaitForCycle(cycle)
+	}
+	return node
+}
+
+// waitForGraphWoken is called when goroutine "me" finished waiting
+// registered by waitForGraphWaiting.
+func waitForGraphWoken(me GoroutineID, node *waitForNode) {
+	if node == nil {
+		return
+	}
+
+	g := &globalWaitForGraph
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	if g.waiting[me] == node {
+		delete(g.waiting, me)
+	}
+}
+
+func (g *waitForGraph) node(waiter GoroutineID) *waitForNode {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	return g.waiting[waiter]
+}
+
+// findCycle returns a cycle of goroutines waiting for each other which
+// includes goroutine "me", or nil if there is no such cycle.
+func (g *waitForGraph) findCycle(me GoroutineID) *WaitForCycle {
+	path := g.findPath(me, me, map[GoroutineID]struct{}{})
+	if path == nil {
+		return nil
+	}
+
+	// The locks are inspected one by one (not atomically), so the path
+	// could be assembled from different moments of time. The goroutines
+	// of a real deadlock do not move, so re-check that the goroutines still
+	// wait the same waiting and the owners still hold the locks.
+	for _, step := range path {
+		if g.node(step.waiter) != step.node || step.node.isExpired() {
+			return nil
+		}
+		if !containsGoroutineID(step.node.locker.waitForOwners(step.waiter, step.node.kind), step.owner) {
+			return nil
+		}
+	}
+
+	cycle := &WaitForCycle{
+		Cycle: make([]WaitForEdge, 0, len(path)),
+	}
+	for _, step := range path {
+		cycle.Cycle = append(cycle.Cycle, WaitForEdge{
+			Waiter:      step.waiter,
+			Locker:      step.node.locker,
+			IsWrite:     step.node.kind != lockWaiterKindRead,
+			Owner:       step.owner,
+			WaiterStack: step.node.stack,
+			OwnerStack:  step.node.locker.acquisitionStackOf(step.owner),
+		})
+	}
+	return cycle
+}
+
+// findPath returns the edges of a path from goroutine "waiter" to
+// goroutine "target" or nil if there is no such path.
+func (g *waitForGraph) findPath(
+	waiter, target GoroutineID,
+	visited map[GoroutineID]struct{},
+) []waitForStep {
+	visited[waiter] = struct{}{}
+	node := g.node(waiter)
+	if node == nil || node.isExpired() {
+		return nil
+	}
+	for _, owner := range node.locker.waitForOwners(waiter, node.kind) {
+		step := waitForStep{
+			waiter: waiter,
+			node:   node,
+			owner:  owner,
+		}
+		if owner == target {
+			return []waitForStep{step}
+		}
+		if _, isVisited := visited[owner]; isVisited {
+			continue
+		}
+		if path := g.findPath(owner, target, visited); path != nil {
+			return append([]waitForStep{step}, path...)
+		}
+	}
+	return nil
+}
+
+func containsGoroutineID(s []GoroutineID, id GoroutineID) bool {
+	for _, item := range s {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+func reportWaitForCycle(cycle *WaitForCycle) {
+	if OnWaitForCycle != nil {
+		OnWaitForCycle(cycle)
+		return
+	}
+
+	_, _ = cycle.WriteTo(debugPanicOut)
+	panic("deadlock: a cycle in the wait-for graph")
+}
+
+func (m *Mutex) waitForOwners(me GoroutineID, _ lockWaiterKind) []GoroutineID {
+	m.internalLocker.Lock()
+	owner := m.monopolizedBy
+	m.internalLocker.Unlock()
+	if owner == 0 || owner == me {
+		return nil
+	}
+	return []GoroutineID{owner}
+}
+
+func (m *Mutex) acquisitionStackOf(g GoroutineID) []uintptr {
+	m.internalLocker.Lock()
+	defer m.internalLocker.Unlock()
+	if m.monopolizedBy != g {
+		return nil
+	}
+	return append([]uintptr{}, m.monopolizedStack...)
+}
+
+func (m *RWMutex) waitForOwners(me GoroutineID, kind lockWaiterKind) []GoroutineID {
+	m.internalLocker.Lock()
+	defer m.internalLocker.Unlock()
+
+	var owners []GoroutineID
+	if m.lockedBy != 0 && m.lockedBy != me {
+		owners = append(owners, m.lockedBy)
+	}
+	switch kind {
+	case lockWaiterKindWrite:
+		for g, count := range m.usedBy {
+			if *count != 0 && g != me && g != m.lockedBy {
+				owners = append(owners, g)
+			}
+		}
+	case lockWaiterKindUpgradeable:
+		if m.upgradeableBy != 0 && m.upgradeableBy != me && m.upgradeableBy != m.lockedBy {
+			owners = append(owners, m.upgradeableBy)
+		}
+	}
+	return owners
+}
+
+func (m *RWMutex) acquisitionStackOf(g GoroutineID) []uintptr {
+	m.internalLocker.Lock()
+	defer m.internalLocker.Unlock()
+	if m.lockedBy == g {
+		return append([]uintptr{}, m.lockedByStack...)
+	}
+	if info := m.usedByInfo[g]; info != nil {
+		return append([]uintptr{}, info.stack...)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package gorex
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withWaitForGraphDetection(t *testing.T, fn func(cycles func() []*WaitForCycle, ctx context.Context)) {
+	var (
+		locker sync.Mutex
+		cycles []*WaitForCycle
+	)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	oldHandler := OnWaitForCycle
+	OnWaitForCycle = func(c *WaitForCycle) {
+		locker.Lock()
+		cycles = append(cycles, c)
+		locker.Unlock()
+		// break the deadlock
+		cancelFn()
+	}
+	SetWaitForGraphDetection(true)
+	defer func() {
+		SetWaitForGraphDetection(false)
+		OnWaitForCycle = oldHandler
+	}()
+
+	fn(func() []*WaitForCycle {
+		locker.Lock()
+		defer locker.Unlock()
+		return cycles
+	}, ctx)
+}
+
+func TestWaitForGraphDetection(t *testing.T) {
+	// deadlock makes two goroutines to lock "first" and then "second" in
+	// the opposite order until the context is done.
+	deadlock := func(
+		ctx context.Context,
+		lockFirst, lockSecond [2]func(ctx context.Context) bool,
+		unlockFirst, unlockSecond [2]func(),
+	) {
+		var wg sync.WaitGroup
+		locked := make(chan struct{})
+		var lockedWG sync.WaitGroup
+		lockedWG.Add(2)
+		for idx := 0; idx < 2; idx++ {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				lockFirst[idx](context.Background())
+				lockedWG.Done()
+				<-locked
+				if lockSecond[idx](ctx) {
+					unlockSecond[idx]()
+				}
+				unlockFirst[idx]()
+			}(idx)
+		}
+		lockedWG.Wait()
+		close(locked)
+		wg.Wait()
+	}
+
+	t.Run("positive", func(t *testing.T) {
+		t.Run("Mutex", func(t *testing.T) {
+			withWaitForGraphDetection(t, func(cycles func() []*WaitForCycle, ctx context.Context) {
+				a, b := &Mutex{}, &Mutex{}
+				deadlock(ctx,
+					[2]func(context.Context) bool{a.LockCtx, b.LockCtx},
+					[2]func(context.Context) bool{b.LockCtx, a.LockCtx},
+					[2]func(){a.Unlock, b.Unlock},
+					[2]func(){b.Unlock, a.Unlock},
+				)
+				if !assert.NotEmpty(t, cycles()) {
+					return
+				}
+				cycle := cycles()[0].Cycle
+				assert.Len(t, cycle, 2)
+				for idx, edge := range cycle {
+					assert.Equal(t, cycle[(idx+1)%len(cycle)].Waiter, edge.Owner)
+					assert.True(t, edge.IsWrite)
+					assert.NotEmpty(t, edge.WaiterStack)
+					assert.NotEmpty(t, edge.OwnerStack)
+				}
+				assert.NotSame(t, cycle[0].Locker, cycle[1].Locker)
+				assert.True(t, strings.Contains(cycles()[0].String(), "a cycle of 2 goroutines"), cycles()[0].String())
+			})
+		})
+		t.Run("RWMutex", func(t *testing.T) {
+			withWaitForGraphDetection(t, func(cycles func() []*WaitForCycle, ctx context.Context) {
+				a, b := &RWMutex{}, &RWMutex{}
+				// the writer of "a" waits for the reader of "a" which
+				// waits for the writer of "b".
+				deadlock(ctx,
+					[2]func(context.Context) bool{a.RLockCtx, b.LockCtx},
+					[2]func(context.Context) bool{b.RLockCtx, a.LockCtx},
+					[2]func(){a.RUnlock, b.Unlock},
+					[2]func(){b.RUnlock, a.Unlock},
+				)
+				if !assert.NotEmpty(t, cycles()) {
+					return
+				}
+				cycle := cycles()[0].Cycle
+				assert.Len(t, cycle, 2)
+				for _, edge := range cycle {
+					assert.NotEmpty(t, edge.OwnerStack)
+				}
+			})
+		})
+	})
+	t.Run("negative", func(t *testing.T) {
+		withWaitForGraphDetection(t, func(cycles func() []*WaitForCycle, ctx context.Context) {
+			a, b := &Mutex{}, &RWMutex{}
+			a.LockDo(func() {
+				var wg sync.WaitGroup
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// waits for "a" while holding "b", but the owner
+					// of "a" does not wait for "b"
+					b.RLockDo(func() {
+						a.LockDo(func() {})
+					})
+				}()
+				time.Sleep(10 * time.Millisecond)
+				b.RLockDo(func() {})
+			})
+			assert.Empty(t, cycles())
+			assert.NoError(t, ctx.Err())
+		})
+		t.Run("expired", func(t *testing.T) {
+			withWaitForGraphDetection(t, func(cycles func() []*WaitForCycle, ctx context.Context) {
+				a, b := &Mutex{}, &Mutex{}
+				ownerID := make(chan GoroutineID)
+				release := make(chan struct{})
+				go func() {
+					a.LockDo(func() {
+						ownerID <- GetGoroutineID()
+						<-release
+					})
+				}()
+				owner := <-ownerID
+				defer close(release)
+
+				b.LockDo(func() {
+					// the owner of "a" waited for "b" with a timeout, which is
+					// expired, but it is not woken up yet
+					node := &waitForNode{
+						locker:   b,
+						kind:     lockWaiterKindWrite,
+						deadline: time.Now().Add(-time.Second),
+					}
+					g := &globalWaitForGraph
+					g.locker.Lock()
+					g.waiting[owner] = node
+					g.locker.Unlock()
+					defer waitForGraphWoken(owner, node)
+
+					assert.False(t, a.LockTimeout(10*time.Millisecond))
+				})
+				assert.Empty(t, cycles())
+				assert.NoError(t, ctx.Err())
+			})
+		})
+	})
+}